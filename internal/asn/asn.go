@@ -0,0 +1,71 @@
+// Package asn looks up the autonomous system (AS) that announces an address.
+package asn
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var lookupTXT = net.LookupTXT
+
+// Cymru looks up the AS of an address with Team Cymru's IP to ASN mapping service, which is queried over DNS.
+// See https://www.team-cymru.com/ip-asn-mapping.
+type Cymru struct{}
+
+// LookupASN returns the number and name of the AS that announces addr, e.g. "AS13335 CLOUDFLARENET - Cloudflare, Inc., US".
+// Private and other non-routable addresses are not looked up: for those, LookupASN returns an empty string.
+func (Cymru) LookupASN(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("invalid address %q", addr)
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return "", nil
+	}
+	// "13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11". An address announced by several ASes lists all of them.
+	origin, err := lookupField(originQuery(ip), 0)
+	if err != nil {
+		return "", err
+	}
+	number := strings.Fields(origin)[0]
+	// "13335 | US | arin | 2010-07-14 | CLOUDFLARENET - Cloudflare, Inc., US"
+	name, err := lookupField("AS"+number+".asn.cymru.com", 4)
+	if err != nil {
+		return "AS" + number, nil
+	}
+	return "AS" + number + " " + name, nil
+}
+
+// originQuery returns the name to query for the origin AS of ip: its octets (IPv4) or nibbles (IPv6) in reverse order,
+// followed by the service's domain.
+func originQuery(ip net.IP) string {
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+		return strings.Join(labels, ".") + ".origin.asn.cymru.com"
+	}
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(ip[i]&0x0f), 16), strconv.FormatUint(uint64(ip[i]>>4), 16))
+	}
+	return strings.Join(labels, ".") + ".origin6.asn.cymru.com"
+}
+
+// lookupField returns the given field of the first TXT record of name. Fields are separated by '|'.
+func lookupField(name string, field int) (string, error) {
+	records, err := lookupTXT(name)
+	if err != nil {
+		return "", fmt.Errorf("lookup %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("lookup %s: no records", name)
+	}
+	fields := strings.Split(records[0], "|")
+	if len(fields) <= field || strings.TrimSpace(fields[field]) == "" {
+		return "", fmt.Errorf("lookup %s: invalid record %q", name, records[0])
+	}
+	return strings.TrimSpace(fields[field]), nil
+}
@@ -0,0 +1,46 @@
+package asn
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestCymru_LookupASN(t *testing.T) {
+	records := map[string][]string{
+		"1.1.1.1.origin.asn.cymru.com": {"13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11"},
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com": {"64496 64497 | 2001:db8::/32 | ZZ | test | 2024-01-01"},
+		"9.9.9.9.origin.asn.cymru.com": {"64511 | 9.9.9.0/24 | ZZ | test | 2024-01-01"},
+		"AS13335.asn.cymru.com":        {"13335 | US | arin | 2010-07-14 | CLOUDFLARENET - Cloudflare, Inc., US"},
+		"AS64496.asn.cymru.com":        {"64496 | ZZ | test | 2024-01-01 | DOC-AS - Documentation"},
+	}
+	lookupTXT = func(name string) ([]string, error) {
+		if txt, ok := records[name]; ok {
+			return txt, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	t.Cleanup(func() { lookupTXT = net.LookupTXT })
+
+	tests := []struct {
+		name    string
+		addr    string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{name: "ipv4", addr: "1.1.1.1", want: "AS13335 CLOUDFLARENET - Cloudflare, Inc., US", wantErr: assert.NoError},
+		{name: "ipv6", addr: "2001:db8::1", want: "AS64496 DOC-AS - Documentation", wantErr: assert.NoError},
+		{name: "no name", addr: "9.9.9.9", want: "AS64511", wantErr: assert.NoError},
+		{name: "private", addr: "192.168.0.1", want: "", wantErr: assert.NoError},
+		{name: "not announced", addr: "8.8.8.8", wantErr: assert.Error},
+		{name: "invalid", addr: "foo", wantErr: assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asn, err := Cymru{}.LookupASN(tt.addr)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, asn)
+		})
+	}
+}
@@ -2,19 +2,28 @@ package discover
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/clambin/pinger/pkg/ping"
 	"github.com/clambin/pinger/pkg/ping/icmp"
+	xicmp "golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"iter"
 	"log/slog"
+	"maps"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Path struct {
-	Hops []*ping.Target
-	lock sync.RWMutex
+	Hops  []*ping.Target
+	notes map[int]string
+	lock  sync.RWMutex
 }
 
 func (p *Path) AddHop() {
@@ -35,33 +44,323 @@ func (p *Path) Len() int {
 	return len(p.Hops)
 }
 
+// Truncate removes all hops beyond the first n.
+func (p *Path) Truncate(n int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n < len(p.Hops) {
+		p.Hops = p.Hops[:n]
+	}
+}
+
+// setHop records the hop at the given TTL, adding hops to the path as needed.
+func (p *Path) setHop(ttl uint8, hop *ping.Target) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for len(p.Hops) < int(ttl) {
+		p.Hops = append(p.Hops, nil)
+	}
+	p.Hops[ttl-1] = hop
+}
+
+// discovered returns true if the hop at the given TTL has been discovered.
+func (p *Path) discovered(ttl uint8) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return int(ttl) <= len(p.Hops) && p.Hops[ttl-1] != nil
+}
+
+// complete returns true if the first n hops of the path have been discovered.
+func (p *Path) complete(n int) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if len(p.Hops) < n {
+		return false
+	}
+	return !slices.Contains(p.Hops[:n], nil)
+}
+
+// Reset removes all hops and notes from the path.
+func (p *Path) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Hops = nil
+	p.notes = nil
+}
+
+// ResetStatistics clears the statistics of all discovered hops.
+func (p *Path) ResetStatistics() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, hop := range p.Hops {
+		if hop != nil {
+			hop.ResetStatistics()
+		}
+	}
+}
+
+// Loops returns the addresses that appear at two or more non-adjacent TTLs, which suggests a routing loop.
+// An address repeated at adjacent TTLs is not reported: that is typically a router that doesn't decrement the TTL
+// or a load-balanced hop, rather than a loop.
+func (p *Path) Loops() []net.IP {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var loops []net.IP
+	lastSeen := make(map[string]int)
+	reported := make(map[string]bool)
+	for i, hop := range p.Hops {
+		if hop == nil {
+			continue
+		}
+		addr := hop.IP.String()
+		if last, ok := lastSeen[addr]; ok && i-last > 1 && !reported[addr] {
+			loops = append(loops, hop.IP)
+			reported[addr] = true
+		}
+		lastSeen[addr] = i
+	}
+	return loops
+}
+
+// SetNote attaches a note to the hop at index idx. An empty note removes it.
+func (p *Path) SetNote(idx int, note string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if note == "" {
+		delete(p.notes, idx)
+		return
+	}
+	if p.notes == nil {
+		p.notes = make(map[int]string)
+	}
+	p.notes[idx] = note
+}
+
+// Note returns the note attached to the hop at index idx.
+func (p *Path) Note(idx int) string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.notes[idx]
+}
+
 type Socket interface {
 	Ping(net.IP, icmp.SequenceNumber, uint8, []byte) error
 	Read(context.Context) (icmp.Response, error)
 }
 
-func Discover(ctx context.Context, route *Path, addr net.IP, s Socket, maxTTL uint8, l *slog.Logger) error {
-	const defaultMaxTTL = 64
+const (
+	// discoveryTimeout bounds the time Discover waits for responses, at the default probe interval.
+	discoveryTimeout = 5 * time.Second
+	// gracePeriod is how long Discover keeps waiting for responses from earlier hops, once the target has been reached.
+	gracePeriod = time.Second
+	// payloadSize is the size of the payload of a discovery probe. It matches the payload of the pings sent by ping.Ping,
+	// so discovery and pinging measure the same packet size.
+	payloadSize = 56
+)
+
+// Discover finds the path to the target. It sends probes with increasing TTL in quick succession, without waiting for
+// responses, and records each hop as its response arrives. Probing stops once the target replies. Events may be nil.
+//
+// A lost probe would leave a gap in the path, so Discover sends up to probesPerTTL probes for each TTL: after each round,
+// it probes the TTLs that haven't answered yet again. The first response for a TTL determines its hop. If probesPerTTL
+// is zero, Discover sends up to 3 probes per TTL. When discovery ends, each TTL up to the target that was probed but
+// didn't answer is recorded as a timeout event.
+//
+// probeInterval is the time between two probes. If zero, it's 50ms. A longer interval avoids flooding slow links and
+// routers that drop bursts of probes. Discover waits proportionally longer for responses.
+func Discover(ctx context.Context, route *Path, target Target, s Socket, maxTTL uint8, probesPerTTL int, probeInterval time.Duration, events *EventLog, l *slog.Logger) error {
+	const (
+		defaultMaxTTL        = 64
+		defaultProbesPerTTL  = 3
+		defaultProbeInterval = 50 * time.Millisecond
+	)
 	if maxTTL == 0 {
 		maxTTL = defaultMaxTTL
 	}
+	if probesPerTTL <= 0 {
+		probesPerTTL = defaultProbesPerTTL
+	}
+	// the sequence number of each probe encodes its TTL and round, so it must fit in 16 bits
+	probesPerTTL = min(probesPerTTL, 255)
+	if probeInterval <= 0 {
+		probeInterval = defaultProbeInterval
+	}
+	timeout := discoveryTimeout
+	if probeInterval > defaultProbeInterval {
+		timeout = time.Duration(float64(discoveryTimeout) * float64(probeInterval) / float64(defaultProbeInterval))
+	}
+
+	discoveryCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	sendCtx, stopSending := context.WithCancel(discoveryCtx)
+	defer stopSending()
+	var reached atomic.Uint32
+	var probes sentProbes
+	sendErr := make(chan error, 1)
+	go func() {
+		if err := sendProbes(sendCtx, s, target, route, maxTTL, probesPerTTL, probeInterval, &reached, &probes); err != nil {
+			sendErr <- err
+			cancel()
+		}
+	}()
 
-	var seq icmp.SequenceNumber
-	payload := make([]byte, 56)
-	for range maxTTL {
-		route.AddHop()
-		ttl := uint8(route.Len())
-		if err := s.Ping(addr, seq, ttl, payload); err != nil {
-			return fmt.Errorf("ping: %w", err)
+	readCtx := discoveryCtx
+	var reachedTTL uint8
+	for reachedTTL == 0 || !route.complete(int(reachedTTL)) {
+		resp, err := readResponse(readCtx, s, target)
+		if err != nil {
+			if readCtx.Err() != nil {
+				break
+			}
+			continue
+		}
+		// each probe's seq is round * maxTTL + TTL - 1
+		seq, ok := probeSequence(resp)
+		if !ok || int(seq) >= probesPerTTL*int(maxTTL) {
+			continue
+		}
+		ttl := uint8(int(seq)%int(maxTTL)) + 1
+		if (reachedTTL > 0 && ttl > reachedTTL) || route.discovered(ttl) {
+			continue
 		}
-		if resp, err := s.Read(ctx); err == nil {
-			l.Debug("hop discovered", "addr", resp.From, "ttl", ttl)
-			route.SetHop(int(ttl-1), &ping.Target{IP: resp.From})
-			if resp.MsgType == ipv4.ICMPTypeEchoReply || resp.MsgType == ipv6.ICMPTypeEchoReply {
+		l.Debug("hop discovered", "target", target, "addr", resp.From, "ttl", ttl)
+		route.setHop(ttl, &ping.Target{IP: resp.From})
+		if resp.From.To4() == nil && resp.From.IsLinkLocalUnicast() {
+			// icmp.Socket doesn't return the zone of the address a response came from. Without it, an IPv6 link-local
+			// hop can't be pinged: every ping to it fails and the hop shows 100% loss.
+			l.Warn("link-local hop can't be pinged: its zone is unknown", "target", target, "addr", resp.From, "ttl", ttl)
+		}
+		if !isEchoReply(resp) {
+			events.add(EventHopDiscovered, resp.From, ttl, seq)
+			continue
+		}
+		events.add(EventTargetReached, resp.From, ttl, seq)
+		if reachedTTL == 0 {
+			var cancelGrace context.CancelFunc
+			readCtx, cancelGrace = context.WithTimeout(discoveryCtx, gracePeriod)
+			defer cancelGrace()
+		}
+		reachedTTL = ttl
+		reached.Store(uint32(ttl))
+		route.Truncate(int(ttl))
+	}
+	stopSending()
+
+	select {
+	case err := <-sendErr:
+		return fmt.Errorf("ping: %w", err)
+	default:
+	}
+	// once the target is reached, a deadline passing while waiting for the missing hops doesn't invalidate the path
+	if err := ctx.Err(); err != nil && (reachedTTL == 0 || !errors.Is(err, context.DeadlineExceeded)) {
+		return err
+	}
+	limit := maxTTL
+	if reachedTTL > 0 {
+		limit = reachedTTL
+	}
+	for ttl, seq := range probes.unanswered(route, limit) {
+		events.add(EventTimeout, nil, ttl, seq)
+	}
+	if reachedTTL == 0 {
+		return fmt.Errorf("no path found to %s: max TTL (%d) exceeded", target, maxTTL)
+	}
+	return nil
+}
+
+// sendProbes sends a probe for each TTL, up to maxTTL, for the given number of rounds, one every interval, until ctx is done.
+// TTLs that have been discovered and TTLs beyond the one at which the target was reached aren't probed again.
+func sendProbes(ctx context.Context, s Socket, target Target, route *Path, maxTTL uint8, rounds int, interval time.Duration, reached *atomic.Uint32, probes *sentProbes) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	payload := make([]byte, payloadSize)
+	for round := range rounds {
+		for ttl := uint8(1); ttl <= maxTTL && ttl != 0; ttl++ {
+			if r := reached.Load(); r > 0 && uint32(ttl) >= r {
+				break
+			}
+			if route.discovered(ttl) {
+				continue
+			}
+			seq := icmp.SequenceNumber(round*int(maxTTL) + int(ttl) - 1)
+			if err := s.Ping(target.IP, seq, ttl, payload); err != nil {
+				return err
+			}
+			probes.add(ttl, seq)
+			select {
+			case <-ctx.Done():
 				return nil
+			case <-ticker.C:
 			}
 		}
-		seq++
 	}
-	return fmt.Errorf("no path found: max TTL (%d) exceeded", maxTTL+1)
+	return nil
+}
+
+// sentProbes records the sequence number of the last probe sent for each TTL.
+type sentProbes struct {
+	seqs map[uint8]icmp.SequenceNumber
+	lock sync.Mutex
+}
+
+func (p *sentProbes) add(ttl uint8, seq icmp.SequenceNumber) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.seqs == nil {
+		p.seqs = make(map[uint8]icmp.SequenceNumber)
+	}
+	p.seqs[ttl] = seq
+}
+
+// unanswered returns the TTLs, up to limit, that were probed but whose hop wasn't discovered, with the sequence number
+// of the last probe sent for each. The TTLs are returned in increasing order.
+func (p *sentProbes) unanswered(route *Path, limit uint8) iter.Seq2[uint8, icmp.SequenceNumber] {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ttls := make(map[uint8]icmp.SequenceNumber)
+	for ttl, seq := range p.seqs {
+		if ttl <= limit && !route.discovered(ttl) {
+			ttls[ttl] = seq
+		}
+	}
+	return func(yield func(uint8, icmp.SequenceNumber) bool) {
+		for _, ttl := range slices.Sorted(maps.Keys(ttls)) {
+			if !yield(ttl, ttls[ttl]) {
+				return
+			}
+		}
+	}
+}
+
+// readResponse returns the next response to a discovery probe. Echo replies that don't come from the target answer pings
+// of a previous trace and are skipped.
+func readResponse(ctx context.Context, s Socket, target Target) (icmp.Response, error) {
+	for {
+		resp, err := s.Read(ctx)
+		if err != nil || !isEchoReply(resp) || resp.From.Equal(target.IP) {
+			return resp, err
+		}
+	}
+}
+
+// probeSequence returns the sequence number of the probe that resp answers.
+func probeSequence(resp icmp.Response) (icmp.SequenceNumber, bool) {
+	switch body := resp.Body.(type) {
+	case *xicmp.Echo:
+		return icmp.SequenceNumber(body.Seq), true
+	case *xicmp.TimeExceeded:
+		// the body holds the IP header and the start of the echo request that expired
+		offset := ipv6.HeaderLen
+		if len(body.Data) > 0 && body.Data[0]>>4 == ipv4.Version {
+			offset = int(body.Data[0]&0x0f) << 2
+		}
+		if len(body.Data) >= offset+8 {
+			return icmp.SequenceNumber(binary.BigEndian.Uint16(body.Data[offset+6:])), true
+		}
+	}
+	return 0, false
+}
+
+func isEchoReply(resp icmp.Response) bool {
+	return resp.MsgType == ipv4.ICMPTypeEchoReply || resp.MsgType == ipv6.ICMPTypeEchoReply
 }
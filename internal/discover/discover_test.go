@@ -3,11 +3,14 @@ package discover
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/clambin/pinger/pkg/ping"
 	icmp2 "github.com/clambin/pinger/pkg/ping/icmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 	"log/slog"
 	"net"
 	"os"
@@ -21,36 +24,267 @@ func TestDiscover(t *testing.T) {
 	s := fakeSocket{
 		hops: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")},
 	}
-	ip, err := net.ResolveIPAddr("ip", "127.0.0.3")
-	require.NoError(t, err)
+	target := Target{Name: "localhost", IP: net.ParseIP("127.0.0.3"), Family: icmp2.IPv4}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	var route Path
-	err = Discover(ctx, &route, ip.IP, &s, 20, l)
+	err := Discover(ctx, &route, target, &s, 20, 0, 0, nil, l)
 	require.NoError(t, err)
 	assert.Equal(t, len(s.hops), route.Len())
 }
 
+func TestDiscover_OutOfOrder(t *testing.T) {
+	// the target's reply arrives before the reply from the first hop.
+	target := Target{IP: net.ParseIP("192.0.2.1"), Family: icmp2.IPv4}
+	s := scriptedSocket{responses: []icmp2.Response{
+		// a reply to a ping of a previous trace is ignored
+		{From: net.ParseIP("192.0.2.99"), MsgType: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{Seq: 0}},
+		{From: target.IP, MsgType: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{Seq: 1}},
+		{From: net.ParseIP("10.0.0.1"), MsgType: ipv4.ICMPTypeTimeExceeded, Body: &icmp.Echo{Seq: 0}},
+	}}
+	var route Path
+	err := Discover(context.Background(), &route, target, &s, 5, 0, 0, nil, slog.Default())
+	require.NoError(t, err)
+	require.Equal(t, 2, route.Len())
+	assert.Equal(t, "10.0.0.1", route.Hops[0].String())
+	assert.Equal(t, "192.0.2.1", route.Hops[1].String())
+}
+
+func TestDiscover_LateEchoReply(t *testing.T) {
+	// the target is one hop away, but the reply to the first probe only arrives once the second probe has been sent.
+	target := Target{IP: net.ParseIP("192.0.2.1"), Family: icmp2.IPv4}
+	s := scriptedSocket{after: 1, responses: []icmp2.Response{
+		{From: target.IP, MsgType: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{Seq: 0}},
+	}}
+	var route Path
+	err := Discover(context.Background(), &route, target, &s, 5, 0, 0, nil, slog.Default())
+	require.NoError(t, err)
+	require.Equal(t, 1, route.Len())
+	assert.Equal(t, "192.0.2.1", route.Hops[0].String())
+}
+
+func TestDiscover_TimeoutEvents(t *testing.T) {
+	// all probes for TTL 2 are lost
+	s := lossySocket{
+		fakeSocket: &fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}},
+		lose:       map[uint8]int{2: 2},
+	}
+	events := NewEventLog(10)
+	var route Path
+	err := Discover(context.Background(), &route, Target{IP: s.hops[2], Family: icmp2.IPv4}, &s, 5, 2, 0, events, slog.Default())
+	require.NoError(t, err)
+
+	var got []string
+	for _, event := range events.Events() {
+		got = append(got, fmt.Sprintf("%s@%d/%d", event.Type, event.TTL, event.Seq))
+	}
+	// each probe's seq is round * maxTTL + TTL - 1: the timeout is reported for the second probe for TTL 2
+	assert.ElementsMatch(t, []string{"hop discovered@1/0", "target reached@3/2", "timeout@2/6"}, got)
+	assert.Equal(t, "timeout@2/6", got[len(got)-1])
+}
+
+func TestDiscover_StopsAtTarget(t *testing.T) {
+	s := fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}}
+	var route Path
+	start := time.Now()
+	err := Discover(context.Background(), &route, Target{IP: s.hops[2], Family: icmp2.IPv4}, &s, 30, 0, 0, nil, slog.Default())
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 3, route.Len())
+	assert.Less(t, s.Pings(), 6)
+}
+
+func TestDiscover_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		probesPerTTL int
+		want         []string
+	}{
+		{name: "single probe leaves a gap", probesPerTTL: 1, want: []string{"10.0.0.1", "", "10.0.0.3"}},
+		{name: "retry fills the gap", probesPerTTL: 3, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first probe for TTL 2 is lost
+			s := lossySocket{
+				fakeSocket: &fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}},
+				lose:       map[uint8]int{2: 1},
+			}
+			var route Path
+			err := Discover(context.Background(), &route, Target{IP: s.hops[2], Family: icmp2.IPv4}, &s, 5, tt.probesPerTTL, 0, nil, slog.Default())
+			require.NoError(t, err)
+			got := make([]string, route.Len())
+			for i, hop := range route.Hops {
+				if hop != nil {
+					got[i] = hop.String()
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDiscover_ProbeInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		min, max time.Duration
+	}{
+		{name: "fast", interval: 10 * time.Millisecond, max: 200 * time.Millisecond},
+		{name: "slow", interval: 100 * time.Millisecond, min: 400 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hops []net.IP
+			for i := range 5 {
+				hops = append(hops, net.IPv4(10, 0, 0, byte(i+1)))
+			}
+			s := fakeSocket{hops: hops}
+			var route Path
+			// the target is reached by the 5th probe, sent 4 intervals after the first one
+			start := time.Now()
+			err := Discover(context.Background(), &route, Target{IP: hops[4], Family: icmp2.IPv4}, &s, 10, 0, tt.interval, nil, slog.Default())
+			elapsed := time.Since(start)
+			require.NoError(t, err)
+			assert.Equal(t, 5, route.Len())
+			assert.GreaterOrEqual(t, elapsed, tt.min)
+			assert.Less(t, elapsed, tt.max)
+		})
+	}
+}
+
+func TestDiscover_NoPath(t *testing.T) {
+	s := scriptedSocket{}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var route Path
+	err := Discover(ctx, &route, Target{IP: net.ParseIP("192.0.2.1"), Family: icmp2.IPv4}, &s, 5, 0, 0, nil, slog.Default())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, route.Len())
+}
+
+func TestProbeSequence(t *testing.T) {
+	echoRequest, err := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 1, Seq: 4}}).Marshal(nil)
+	require.NoError(t, err)
+	ipv4Header := make([]byte, ipv4.HeaderLen)
+	ipv4Header[0] = ipv4.Version<<4 | ipv4.HeaderLen>>2
+	ipv6Header := make([]byte, ipv6.HeaderLen)
+	ipv6Header[0] = ipv6.Version << 4
+
+	tests := []struct {
+		name   string
+		body   icmp.MessageBody
+		want   icmp2.SequenceNumber
+		wantOK assert.BoolAssertionFunc
+	}{
+		{name: "echo reply", body: &icmp.Echo{Seq: 3}, want: 3, wantOK: assert.True},
+		{name: "ipv4 time exceeded", body: &icmp.TimeExceeded{Data: append(ipv4Header, echoRequest...)}, want: 4, wantOK: assert.True},
+		{name: "ipv6 time exceeded", body: &icmp.TimeExceeded{Data: append(ipv6Header, echoRequest...)}, want: 4, wantOK: assert.True},
+		{name: "truncated time exceeded", body: &icmp.TimeExceeded{Data: ipv4Header}, wantOK: assert.False},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, ok := probeSequence(icmp2.Response{Body: tt.body})
+			tt.wantOK(t, ok)
+			assert.Equal(t, tt.want, seq)
+		})
+	}
+}
+
+func TestPath_Loops(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []string
+		want []net.IP
+	}{
+		{
+			name: "no loop",
+			hops: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name: "adjacent repeat is not a loop",
+			hops: []string{"10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name: "loop",
+			hops: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.2", "10.0.0.3", "10.0.0.2"},
+			want: []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")},
+		},
+		{
+			name: "loop across a missing hop",
+			hops: []string{"10.0.0.1", "", "10.0.0.1"},
+			want: []net.IP{net.ParseIP("10.0.0.1")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var route Path
+			for i, hop := range tt.hops {
+				route.AddHop()
+				if hop != "" {
+					route.SetHop(i, &ping.Target{IP: net.ParseIP(hop)})
+				}
+			}
+			assert.Equal(t, tt.want, route.Loops())
+		})
+	}
+}
+
+func TestPath_Note(t *testing.T) {
+	var route Path
+	route.AddHop()
+	route.AddHop()
+	assert.Empty(t, route.Note(1))
+
+	route.SetNote(1, "ISP handoff")
+	assert.Equal(t, "ISP handoff", route.Note(1))
+	assert.Empty(t, route.Note(0))
+
+	route.SetNote(1, "")
+	assert.Empty(t, route.Note(1))
+}
+
 var _ Socket = &fakeSocket{}
 
 type fakeSocket struct {
-	hops  []net.IP
-	queue []icmp2.Response
-	lock  sync.Mutex
+	routes map[string][]net.IP
+	sent   map[string]int
+	hops   []net.IP
+	queue  []icmp2.Response
+	delay  time.Duration
+	pings  int
+	lock   sync.Mutex
 }
 
-func (f *fakeSocket) Ping(_ net.IP, seq icmp2.SequenceNumber, ttl uint8, payload []byte) error {
+func (f *fakeSocket) Ping(ip net.IP, seq icmp2.SequenceNumber, ttl uint8, payload []byte) error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
+	f.pings++
+	if f.sent == nil {
+		f.sent = make(map[string]int)
+	}
+	f.sent[ip.String()]++
+	hops := f.hops
+	if route, ok := f.routes[ip.String()]; ok {
+		hops = route
+	}
+	if len(hops) == 0 {
+		// not a traced target: answer as if the ping reached its destination
+		hops = []net.IP{ip}
+	}
 	idx := int(ttl) - 1
-	msgType := ipv4.ICMPTypeTimeExceeded
-	if idx >= len(f.hops)-1 {
-		msgType = ipv4.ICMPTypeEchoReply
-		idx = len(f.hops) - 1
+	var msgType icmp.Type = ipv4.ICMPTypeTimeExceeded
+	reply := icmp.Type(ipv4.ICMPTypeEchoReply)
+	if ip.To4() == nil {
+		msgType, reply = ipv6.ICMPTypeTimeExceeded, ipv6.ICMPTypeEchoReply
+	}
+	if idx >= len(hops)-1 {
+		msgType = reply
+		idx = len(hops) - 1
 	}
 	f.queue = append(f.queue, icmp2.Response{
-		From:     f.hops[idx],
+		From:     hops[idx],
 		MsgType:  msgType,
 		Body:     &icmp.Echo{Seq: int(seq), Data: payload},
 		Received: time.Now(),
@@ -58,13 +292,91 @@ func (f *fakeSocket) Ping(_ net.IP, seq icmp2.SequenceNumber, ttl uint8, payload
 	return nil
 }
 
-func (f *fakeSocket) Read(_ context.Context) (icmp2.Response, error) {
+func (f *fakeSocket) Read(ctx context.Context) (icmp2.Response, error) {
+	for ctx.Err() == nil {
+		f.lock.Lock()
+		if len(f.queue) > 0 && time.Since(f.queue[0].Received) >= f.delay {
+			response := f.queue[0]
+			f.queue = f.queue[1:]
+			f.lock.Unlock()
+			return response, nil
+		}
+		f.lock.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return icmp2.Response{}, ctx.Err()
+}
+
+func (f *fakeSocket) Resolve(string) (net.IP, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeSocket) Serve(context.Context) {}
+
+func (f *fakeSocket) Pings() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.pings
+}
+
+func (f *fakeSocket) Sent(ip string) int {
 	f.lock.Lock()
 	defer f.lock.Unlock()
-	if len(f.queue) == 0 {
-		return icmp2.Response{}, errors.New("queue is empty")
+	return f.sent[ip]
+}
+
+// lossySocket loses the first probes sent with a given TTL.
+type lossySocket struct {
+	*fakeSocket
+	lose map[uint8]int
+}
+
+func (s *lossySocket) Ping(ip net.IP, seq icmp2.SequenceNumber, ttl uint8, payload []byte) error {
+	s.lock.Lock()
+	if s.lose[ttl] > 0 {
+		s.lose[ttl]--
+		s.lock.Unlock()
+		return nil
+	}
+	s.lock.Unlock()
+	return s.fakeSocket.Ping(ip, seq, ttl, payload)
+}
+
+var _ Socket = &scriptedSocket{}
+
+// scriptedSocket returns its responses, in order, once more than after probes have been sent. It then blocks until ctx
+// is done.
+type scriptedSocket struct {
+	responses []icmp2.Response
+	after     int
+	pings     int
+	lock      sync.Mutex
+}
+
+func (s *scriptedSocket) Ping(net.IP, icmp2.SequenceNumber, uint8, []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pings++
+	return nil
+}
+
+func (s *scriptedSocket) Read(ctx context.Context) (icmp2.Response, error) {
+	for {
+		s.lock.Lock()
+		if s.pings > s.after && len(s.responses) > 0 {
+			resp := s.responses[0]
+			s.responses = s.responses[1:]
+			s.lock.Unlock()
+			return resp, nil
+		}
+		s.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return icmp2.Response{}, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
 	}
-	response := f.queue[0]
-	f.queue = f.queue[1:]
-	return response, nil
 }
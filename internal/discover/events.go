@@ -0,0 +1,134 @@
+package discover
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventHopDiscovered EventType = iota
+	EventTargetReached
+	EventReply
+	EventTimeout
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventHopDiscovered:
+		return "hop discovered"
+	case EventTargetReached:
+		return "target reached"
+	case EventReply:
+		return "reply"
+	case EventTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a single event of a trace. TTL is only set for events that happen during path discovery.
+// Seq is the sequence number of the probe or ping the event is about: for a timeout, that of the last probe sent for
+// its TTL. Events carry no RTT: ping.Ping matches replies to their pings internally and doesn't expose the latency of a
+// single reply.
+type Event struct {
+	Time time.Time
+	IP   net.IP
+	Type EventType
+	TTL  uint8
+	Seq  icmp.SequenceNumber
+}
+
+// EventLog keeps the most recent events of a trace in a ring buffer, so applications embedding vizroute can show them
+// without intercepting its logging. Applications can also subscribe to receive each event as it is added.
+// A nil EventLog discards all events.
+type EventLog struct {
+	events      []Event
+	subscribers []chan Event
+	next        int
+	full        bool
+	lock        sync.Mutex
+}
+
+// NewEventLog returns an EventLog that holds the last size events.
+func NewEventLog(size int) *EventLog {
+	return &EventLog{events: make([]Event, max(size, 1))}
+}
+
+// Add records an event.
+func (e *EventLog) Add(event Event) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events[e.next] = event
+	e.next = (e.next + 1) % len(e.events)
+	e.full = e.full || e.next == 0
+	for _, ch := range e.subscribers {
+		// don't let a slow subscriber hold up the trace: drop the event if its channel is full
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives each event added from now on, until ctx is done. The channel buffers up to
+// size events: if the subscriber doesn't keep up, further events are dropped. The channel is closed when ctx is done.
+func (e *EventLog) Subscribe(ctx context.Context, size int) <-chan Event {
+	ch := make(chan Event, size)
+	if e == nil {
+		close(ch)
+		return ch
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.subscribers = append(e.subscribers, ch)
+	go func() {
+		<-ctx.Done()
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.subscribers = slices.DeleteFunc(e.subscribers, func(c chan Event) bool { return c == ch })
+		close(ch)
+	}()
+	return ch
+}
+
+// Events returns the recorded events, oldest first.
+func (e *EventLog) Events() []Event {
+	if e == nil {
+		return nil
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.full {
+		return append([]Event(nil), e.events[:e.next]...)
+	}
+	return append(append([]Event(nil), e.events[e.next:]...), e.events[:e.next]...)
+}
+
+func (e *EventLog) add(eventType EventType, ip net.IP, ttl uint8, seq icmp.SequenceNumber) {
+	e.Add(Event{Time: time.Now(), IP: ip, Type: eventType, TTL: ttl, Seq: seq})
+}
+
+// eventSocket records the replies read from the socket in an EventLog.
+type eventSocket struct {
+	ping.Socket
+	events *EventLog
+}
+
+func (s eventSocket) Read(ctx context.Context) (icmp.Response, error) {
+	resp, err := s.Socket.Read(ctx)
+	if err == nil && isEchoReply(resp) {
+		s.events.add(EventReply, resp.From, 0, resp.SequenceNumber())
+	}
+	return resp, err
+}
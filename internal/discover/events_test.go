@@ -0,0 +1,81 @@
+package discover
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEventLog(t *testing.T) {
+	events := NewEventLog(3)
+	assert.Empty(t, events.Events())
+
+	for ttl := range uint8(5) {
+		events.Add(Event{Type: EventHopDiscovered, TTL: ttl + 1})
+	}
+	var ttls []uint8
+	for _, event := range events.Events() {
+		ttls = append(ttls, event.TTL)
+	}
+	assert.Equal(t, []uint8{3, 4, 5}, ttls)
+
+	var nilLog *EventLog
+	nilLog.Add(Event{})
+	assert.Nil(t, nilLog.Events())
+}
+
+func TestEventLog_Subscribe(t *testing.T) {
+	events := NewEventLog(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := events.Subscribe(ctx, 2)
+
+	// events beyond the channel's buffer are dropped
+	for ttl := range uint8(3) {
+		events.Add(Event{Type: EventHopDiscovered, TTL: ttl + 1})
+	}
+	assert.Equal(t, uint8(1), (<-ch).TTL)
+	assert.Equal(t, uint8(2), (<-ch).TTL)
+	assert.Len(t, events.Events(), 3)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, ok := <-ch
+		return !ok
+	}, time.Second, time.Millisecond)
+	events.Add(Event{Type: EventReply})
+
+	var nilLog *EventLog
+	_, ok := <-nilLog.Subscribe(context.Background(), 1)
+	assert.False(t, ok)
+}
+
+func TestTracer_Events(t *testing.T) {
+	s := fakeSocket{hops: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}}
+	tracer := Tracer{
+		Path:    &Path{},
+		Socket:  &s,
+		Events:  NewEventLog(10),
+		Logger:  slog.Default(),
+		Options: PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracer.Start(ctx, Target{IP: s.hops[1], Family: icmp.IPv4})
+	defer tracer.Stop()
+
+	assert.Eventually(t, func() bool { return len(tracer.Events.Events()) >= 4 }, time.Second, 10*time.Millisecond)
+	events := tracer.Events.Events()
+	require.GreaterOrEqual(t, len(events), 4)
+	assert.Equal(t, EventHopDiscovered, events[0].Type)
+	assert.Equal(t, uint8(1), events[0].TTL)
+	assert.Equal(t, EventTargetReached, events[1].Type)
+	assert.Equal(t, uint8(2), events[1].TTL)
+	assert.Equal(t, EventReply, events[2].Type)
+	// ping.Ping numbers its pings from 1
+	assert.NotZero(t, events[2].Seq)
+}
@@ -0,0 +1,221 @@
+package discover
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// PingOptions configures how Ping pings the hops of a Path.
+type PingOptions struct {
+	// Interval between two pings to the same hop.
+	Interval time.Duration
+	// Timeout after which a ping is considered lost.
+	Timeout time.Duration
+	// WarmUp is the period at the start of pinging whose samples are discarded,
+	// so the inflated latency of the first probes (ARP/ND resolution, cold caches) doesn't skew the statistics.
+	WarmUp time.Duration
+	// StatsWindow, if set, clears the statistics at this interval, so they only reflect the most recent window rather
+	// than everything since pinging started. The window starts after the warm-up period.
+	StatsWindow time.Duration
+	// SkipDestination excludes the last hop, i.e. the destination, from pinging.
+	// Its row then only shows what was learned during path discovery.
+	SkipDestination bool
+}
+
+// Ping continuously pings all hops of the path, until ctx is done.
+func Ping(ctx context.Context, route *Path, s ping.Socket, options PingOptions, l *slog.Logger) {
+	pingHops(ctx, route, resettingSocket(ctx, route, s, options, l), options, l)
+}
+
+// pingFor pings all hops of the path for the given duration. It then waits for the replies that are still outstanding
+// (see drain).
+func pingFor(ctx context.Context, route *Path, s ping.Socket, options PingOptions, duration time.Duration, l *slog.Logger) {
+	pingCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	// drain reads through the same socket, so it also ignores the replies to pings sent before the last reset
+	s = resettingSocket(pingCtx, route, s, options, l)
+	pingHops(pingCtx, route, s, options, l)
+	drain(ctx, route, s, options, l)
+}
+
+func pingHops(ctx context.Context, route *Path, s ping.Socket, options PingOptions, l *slog.Logger) {
+	hops := route.Hops
+	if options.SkipDestination && len(hops) > 0 {
+		hops = hops[:len(hops)-1]
+	}
+	ping.Ping(ctx, hops, contextSocket{Socket: s}, options.Interval, options.Timeout, l)
+}
+
+// resettingSocket resets the path's statistics at the end of the warm-up period and of each stats window, until ctx is
+// done. It returns the socket to ping through, which ignores the replies to pings sent before a reset (see resetSocket).
+func resettingSocket(ctx context.Context, route *Path, s ping.Socket, options PingOptions, l *slog.Logger) ping.Socket {
+	if options.WarmUp <= 0 && options.StatsWindow <= 0 {
+		return s
+	}
+	rs := newResetSocket(s, options.Timeout)
+	go resetStatistics(ctx, route, rs, options, l)
+	return rs
+}
+
+// drain counts the replies to the pings that were still outstanding when pinging stopped. It returns once every hop
+// has received a reply to each of its pings, or once the ping timeout has passed, or when ctx is done.
+func drain(ctx context.Context, route *Path, s ping.Socket, options PingOptions, l *slog.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+	hops := make(map[string]*ping.Target)
+	for _, hop := range route.Hops {
+		if hop != nil {
+			hops[hop.String()] = hop
+		}
+	}
+	for !answered(hops) {
+		resp, err := s.Read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				l.Debug("replies outstanding after timeout", "timeout", options.Timeout)
+				return
+			}
+			continue
+		}
+		if hop, ok := hops[resp.From.String()]; ok && isEchoReply(resp) {
+			hop.Received(true, resp.SequenceNumber())
+		}
+	}
+}
+
+// answered returns true if all hops have received a reply to each of their pings.
+func answered(hops map[string]*ping.Target) bool {
+	for _, hop := range hops {
+		if statistics := hop.Statistics(); statistics.Received < statistics.Sent {
+			return false
+		}
+	}
+	return true
+}
+
+// resetStatistics clears the path's statistics at the end of the warm-up period and at the end of each stats window.
+func resetStatistics(ctx context.Context, route *Path, s *resetSocket, options PingOptions, l *slog.Logger) {
+	if options.WarmUp > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(options.WarmUp):
+			s.reset(route)
+			l.Debug("warm-up period ended", "duration", options.WarmUp)
+		}
+	}
+	if options.StatsWindow <= 0 {
+		return
+	}
+	ticker := time.NewTicker(options.StatsWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reset(route)
+			l.Debug("stats window ended", "duration", options.StatsWindow)
+		}
+	}
+}
+
+// resetSocket discards the replies to pings sent before the statistics were last reset. Resetting a hop's statistics
+// doesn't clear its outstanding pings, so a late reply to one of them would otherwise be counted as received, in
+// statistics that don't include the ping itself.
+//
+// ping.Ping records a ping as sent only once the socket has sent it. A ping sent while the statistics are being reset
+// may therefore be counted as sent, while its reply is discarded.
+type resetSocket struct {
+	ping.Socket
+	timeout time.Duration
+	// recent holds the pings sent to each address within the last timeout, oldest first.
+	recent map[string][]sentPing
+	// stale holds the pings sent before the last reset that may still be answered.
+	stale map[string]map[icmp.SequenceNumber]time.Time
+	lock  sync.Mutex
+}
+
+type sentPing struct {
+	seq icmp.SequenceNumber
+	at  time.Time
+}
+
+func newResetSocket(s ping.Socket, timeout time.Duration) *resetSocket {
+	return &resetSocket{Socket: s, timeout: timeout, recent: make(map[string][]sentPing)}
+}
+
+func (s *resetSocket) Ping(ip net.IP, seq icmp.SequenceNumber, ttl uint8, payload []byte) error {
+	s.lock.Lock()
+	now := time.Now()
+	recent := append(s.recent[ip.String()], sentPing{seq: seq, at: now})
+	for len(recent) > 0 && now.Sub(recent[0].at) > s.timeout {
+		recent = recent[1:]
+	}
+	s.recent[ip.String()] = recent
+	s.lock.Unlock()
+	return s.Socket.Ping(ip, seq, ttl, payload)
+}
+
+func (s *resetSocket) Read(ctx context.Context) (icmp.Response, error) {
+	for {
+		resp, err := s.Socket.Read(ctx)
+		if err != nil || !isEchoReply(resp) || !s.isStale(resp) {
+			return resp, err
+		}
+	}
+}
+
+// isStale returns true if resp answers a ping sent before the last reset.
+func (s *resetSocket) isStale(resp icmp.Response) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pings := s.stale[resp.From.String()]
+	at, ok := pings[resp.SequenceNumber()]
+	if !ok {
+		return false
+	}
+	delete(pings, resp.SequenceNumber())
+	// ping.Ping's sequence numbers wrap around: a ping sent longer than the timeout ago is no longer outstanding
+	return time.Since(at) <= s.timeout
+}
+
+// reset clears the path's statistics and marks all pings that may still be answered as stale.
+func (s *resetSocket) reset(route *Path) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stale = make(map[string]map[icmp.SequenceNumber]time.Time, len(s.recent))
+	for addr, pings := range s.recent {
+		s.stale[addr] = make(map[icmp.SequenceNumber]time.Time, len(pings))
+		for _, p := range pings {
+			s.stale[addr][p.seq] = p.at
+		}
+	}
+	route.ResetStatistics()
+}
+
+// contextSocket returns context.Canceled from Read once the context is done.
+// ping.Ping only stops reading responses when Read returns context.Canceled, which icmp.Socket doesn't do.
+// Neither does a context whose deadline expired: its error is context.DeadlineExceeded.
+//
+// ping.Ping doesn't wait for its reader to stop. Checking ctx before reading keeps that reader from taking responses
+// meant for a new trace on the same socket.
+type contextSocket struct {
+	ping.Socket
+}
+
+func (s contextSocket) Read(ctx context.Context) (icmp.Response, error) {
+	if ctx.Err() != nil {
+		return icmp.Response{}, context.Canceled
+	}
+	resp, err := s.Socket.Read(ctx)
+	if err != nil && ctx.Err() != nil {
+		err = context.Canceled
+	}
+	return resp, err
+}
@@ -0,0 +1,238 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPing_WarmUp(t *testing.T) {
+	s := fakeSocket{hops: []net.IP{net.ParseIP("127.0.0.1")}}
+	var route Path
+	route.AddHop()
+	route.SetHop(0, &ping.Target{IP: s.hops[0]})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Ping(ctx, &route, &s, PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second, WarmUp: 200 * time.Millisecond}, slog.Default())
+
+	// samples sent during the warm-up period are not included in the statistics
+	assert.Eventually(t, func() bool {
+		return s.Pings() >= 30
+	}, 2*time.Second, 10*time.Millisecond)
+	statistics := route.Hops[0].Statistics()
+	assert.NotZero(t, statistics.Sent)
+	assert.Less(t, statistics.Sent, s.Pings()-5)
+}
+
+func TestPing_LateReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		options PingOptions
+		// duration, if set, pings for that duration and then drains the outstanding replies, as Tracer.Trace does
+		duration time.Duration
+	}{
+		{name: "warm-up", options: PingOptions{WarmUp: 50 * time.Millisecond}},
+		{name: "stats window", options: PingOptions{StatsWindow: 50 * time.Millisecond}},
+		// the pings sent after the reset are never answered, so drain keeps reading until the timeout
+		{name: "drain", options: PingOptions{WarmUp: 50 * time.Millisecond}, duration: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the pings sent before the reset are answered after it, the ones sent after it aren't answered at all
+			f := fakeSocket{hops: []net.IP{net.ParseIP("127.0.0.1")}, delay: 100 * time.Millisecond}
+			s := mutedSocket{Socket: &f, after: time.Now().Add(50 * time.Millisecond)}
+			var route Path
+			route.AddHop()
+			route.SetHop(0, &ping.Target{IP: net.ParseIP("127.0.0.1")})
+
+			options := tt.options
+			options.Interval = 10 * time.Millisecond
+			options.Timeout = 300 * time.Millisecond
+			if tt.duration > 0 {
+				pingFor(context.Background(), &route, s, options, tt.duration, slog.Default())
+				assert.Zero(t, route.Hops[0].Statistics().Received)
+			} else {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go Ping(ctx, &route, s, options, slog.Default())
+
+				// the replies to the pings sent before the reset must never be counted as received
+				for deadline := time.Now().Add(250 * time.Millisecond); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+					assert.Zero(t, route.Hops[0].Statistics().Received)
+				}
+			}
+			// the pings sent before the reset were answered, and all replies were read.
+			// Don't check Sent: with a stats window, it may just have been reset.
+			assert.NotZero(t, f.Pings())
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			assert.Empty(t, f.queue)
+		})
+	}
+}
+
+// mutedSocket only sends the pings sent before a given time.
+type mutedSocket struct {
+	ping.Socket
+	after time.Time
+}
+
+func (s mutedSocket) Ping(ip net.IP, seq icmp.SequenceNumber, ttl uint8, payload []byte) error {
+	if time.Now().After(s.after) {
+		return nil
+	}
+	return s.Socket.Ping(ip, seq, ttl, payload)
+}
+
+func TestPing_StatsWindow(t *testing.T) {
+	s := fakeSocket{hops: []net.IP{net.ParseIP("127.0.0.1")}}
+	var route Path
+	route.AddHop()
+	route.SetHop(0, &ping.Target{IP: s.hops[0]})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Ping(ctx, &route, &s, PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second, StatsWindow: 100 * time.Millisecond}, slog.Default())
+
+	// the statistics only cover the current window, so they never reach the number of pings sent
+	assert.Eventually(t, func() bool {
+		return s.Pings() >= 40
+	}, time.Second, 10*time.Millisecond)
+	statistics := route.Hops[0].Statistics()
+	assert.LessOrEqual(t, statistics.Sent, 15)
+}
+
+func TestPing_Timeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    assert.ValueAssertionFunc
+	}{
+		{name: "replies within timeout", timeout: time.Second, want: assert.NotZero},
+		{name: "replies after timeout", timeout: 20 * time.Millisecond, want: assert.Zero},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := fakeSocket{hops: []net.IP{net.ParseIP("127.0.0.1")}, delay: 100 * time.Millisecond}
+			var route Path
+			route.AddHop()
+			route.SetHop(0, &ping.Target{IP: s.hops[0]})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			Ping(ctx, &route, &s, PingOptions{Interval: 50 * time.Millisecond, Timeout: tt.timeout}, slog.Default())
+
+			statistics := route.Hops[0].Statistics()
+			assert.NotZero(t, statistics.Sent)
+			tt.want(t, statistics.Received)
+		})
+	}
+}
+
+func TestPing_SkipDestination(t *testing.T) {
+	s := fakeSocket{}
+	var route Path
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		route.AddHop()
+		route.SetHop(i, &ping.Target{IP: net.ParseIP(ip)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	Ping(ctx, &route, &s, PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second, SkipDestination: true}, slog.Default())
+
+	assert.NotZero(t, s.Sent("10.0.0.1"))
+	assert.Zero(t, s.Sent("10.0.0.2"))
+	assert.Zero(t, route.Hops[1].Statistics().Sent)
+}
+
+func TestPing_SameSequenceNumbers(t *testing.T) {
+	// all hops are pinged with the same sequence numbers. Replies must be attributed to the hop that sent them.
+	s := droppingSocket{fakeSocket: &fakeSocket{}, drop: "10.0.0.2"}
+	var route Path
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		route.AddHop()
+		route.SetHop(i, &ping.Target{IP: net.ParseIP(ip)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	Ping(ctx, &route, s, PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second}, slog.Default())
+
+	assert.NotZero(t, route.Hops[0].Statistics().Received)
+	assert.NotZero(t, route.Hops[1].Statistics().Sent)
+	assert.Zero(t, route.Hops[1].Statistics().Received)
+}
+
+// droppingSocket drops all replies from one address.
+type droppingSocket struct {
+	*fakeSocket
+	drop string
+}
+
+func (s droppingSocket) Read(ctx context.Context) (icmp.Response, error) {
+	for {
+		resp, err := s.fakeSocket.Read(ctx)
+		if err != nil || resp.From.String() != s.drop {
+			return resp, err
+		}
+	}
+}
+
+func TestPing_Cancel(t *testing.T) {
+	s := erroringSocket{fakeSocket: &fakeSocket{}}
+	var route Path
+	route.AddHop()
+	route.SetHop(0, &ping.Target{IP: net.ParseIP("10.0.0.1")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Ping(ctx, &route, &s, PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second}, slog.Default())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Ping didn't stop")
+	}
+	// the goroutine receiving responses stops reading once ctx is done
+	reads := s.Reads()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, reads, s.Reads())
+}
+
+// erroringSocket's Read returns a generic error once ctx is done, rather than ctx's error, like icmp.Socket does.
+type erroringSocket struct {
+	*fakeSocket
+	reads int
+	lock  sync.Mutex
+}
+
+func (s *erroringSocket) Read(ctx context.Context) (icmp.Response, error) {
+	s.lock.Lock()
+	s.reads++
+	s.lock.Unlock()
+	resp, err := s.fakeSocket.Read(ctx)
+	if err != nil {
+		err = errors.New("read failed")
+	}
+	return resp, err
+}
+
+func (s *erroringSocket) Reads() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.reads
+}
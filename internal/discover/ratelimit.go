@@ -0,0 +1,65 @@
+package discover
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimitedSocket spaces out the probes sent through a socket, so they don't exceed a number of probes per second.
+// Ping blocks until the probe can be sent, or until ctx is done.
+type rateLimitedSocket struct {
+	ping.Socket
+	ctx     context.Context
+	limiter *tokenBucket
+}
+
+func newRateLimitedSocket(ctx context.Context, s ping.Socket, pps int) rateLimitedSocket {
+	return rateLimitedSocket{Socket: s, ctx: ctx, limiter: newTokenBucket(pps)}
+}
+
+func (s rateLimitedSocket) Ping(ip net.IP, seq icmp.SequenceNumber, ttl uint8, payload []byte) error {
+	if err := s.limiter.wait(s.ctx); err != nil {
+		return err
+	}
+	return s.Socket.Ping(ip, seq, ttl, payload)
+}
+
+// tokenBucket hands out rate tokens per second. It holds at most one token, so the tokens are evenly spaced rather than
+// handed out in bursts.
+type tokenBucket struct {
+	interval time.Duration
+	next     time.Time
+	lock     sync.Mutex
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{interval: time.Second / time.Duration(max(rate, 1))}
+}
+
+// wait blocks until a token is available and takes it, or until ctx is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.lock.Lock()
+	now := time.Now()
+	at := now
+	if b.next.After(now) {
+		at = b.next
+	}
+	b.next = at.Add(b.interval)
+	b.lock.Unlock()
+
+	if at.Equal(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
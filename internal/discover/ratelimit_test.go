@@ -0,0 +1,41 @@
+package discover
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedSocket(t *testing.T) {
+	var f fakeSocket
+	s := newRateLimitedSocket(context.Background(), &f, 50)
+
+	const pings = 11
+	start := time.Now()
+	for seq := range pings {
+		require.NoError(t, s.Ping(net.ParseIP("127.0.0.1"), icmp.SequenceNumber(seq), 64, nil))
+	}
+	elapsed := time.Since(start)
+	assert.Equal(t, pings, f.Pings())
+	// the first ping is sent at once, the next ones 20ms apart
+	assert.GreaterOrEqual(t, elapsed, (pings-1)*20*time.Millisecond)
+	assert.LessOrEqual(t, float64(pings-1)/elapsed.Seconds(), 50.0)
+}
+
+func TestRateLimitedSocket_Cancel(t *testing.T) {
+	var f fakeSocket
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newRateLimitedSocket(ctx, &f, 1)
+
+	require.NoError(t, s.Ping(net.ParseIP("127.0.0.1"), 1, 64, nil))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	assert.ErrorIs(t, s.Ping(net.ParseIP("127.0.0.1"), 2, 64, nil), context.Canceled)
+	assert.Equal(t, 1, f.Pings())
+}
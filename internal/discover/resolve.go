@@ -0,0 +1,150 @@
+package discover
+
+import (
+	"fmt"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var _ slog.LogValuer = Target{}
+
+// Target identifies the host being traced: the name as given by the user, the address it resolved to and that address's family.
+// It is created once, when resolving the host, and shared by everything that needs to refer to the traced host.
+type Target struct {
+	Name   string
+	IP     net.IP
+	Family icmp.Transport
+}
+
+func (t Target) String() string {
+	if t.IP == nil {
+		return t.Name
+	}
+	if t.Name == "" || t.Name == t.IP.String() {
+		return t.IP.String()
+	}
+	return t.Name + " (" + t.IP.String() + ")"
+}
+
+func (t Target) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", t.Name),
+		slog.String("ip", t.IP.String()),
+		slog.String("family", t.Family.String()),
+	)
+}
+
+// ParseHost returns the host name or IP address in s, which may be a URL (e.g. "https://user@example.com:8443/path")
+// or a host and port (e.g. "example.com:443" or "[2001:db8::1]:443"). Anything else is returned as is.
+func ParseHost(s string) string {
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+var lookupIP = net.LookupIP
+
+// hasRoute returns true if the system has a route to ip. Dialing a UDP address only selects the route: no packets are sent.
+var hasRoute = func(ip net.IP) bool {
+	c, err := net.Dial("udp", net.JoinHostPort(ip.String(), "33434"))
+	if err != nil {
+		return false
+	}
+	_ = c.Close()
+	return true
+}
+
+// Resolve resolves host to its first address of the requested family. If host is an IP address,
+// Resolve uses it as is, without querying DNS.
+//
+// If family is icmp.IPv4|icmp.IPv6, Resolve picks the family: it prefers the host's first IPv6 address,
+// if the system has a route to it, and falls back to its first IPv4 address otherwise. A host without IPv4 addresses
+// resolves to its first IPv6 address, route or not.
+func Resolve(host string, family icmp.Transport) (Target, error) {
+	targets, err := ResolveAll(host, family)
+	if err != nil {
+		return Target{}, err
+	}
+	if family == icmp.IPv4|icmp.IPv6 {
+		for _, target := range targets {
+			if target.Family == icmp.IPv6 && hasRoute(target.IP) {
+				return target, nil
+			}
+		}
+		for _, target := range targets {
+			if target.Family == icmp.IPv4 {
+				return target, nil
+			}
+		}
+	}
+	return targets[0], nil
+}
+
+// ResolveAll resolves host to all its addresses of the requested family (which may be icmp.IPv4|icmp.IPv6),
+// in the order returned by DNS. If host is an IP address, ResolveAll uses it as is, without querying DNS.
+func ResolveAll(host string, family icmp.Transport) ([]Target, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if getFamily(ip)&family == 0 {
+			return nil, fmt.Errorf("%s is an %s address, not an %s address", host, getFamily(ip), family)
+		}
+		return []Target{{Name: host, IP: ip, Family: getFamily(ip)}}, nil
+	}
+	ips, err := lookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	var targets []Target
+	for _, ip := range ips {
+		if getFamily(ip)&family != 0 {
+			targets = append(targets, Target{Name: host, IP: ip, Family: getFamily(ip)})
+		}
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+	if family == icmp.IPv4|icmp.IPv6 {
+		return nil, fmt.Errorf("%s does not have an address", host)
+	}
+	return nil, fmt.Errorf("%s does not have an %s address", host, family)
+}
+
+// ResolveFamilies resolves host and returns a Target for its first IPv4 and its first IPv6 address.
+// It returns an error if the host does not have an address in both families.
+func ResolveFamilies(host string) (Target, Target, error) {
+	ips, err := lookupIP(host)
+	if err != nil {
+		return Target{}, Target{}, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	var v4, v6 Target
+	for _, ip := range ips {
+		switch family := getFamily(ip); {
+		case family == icmp.IPv4 && v4.IP == nil:
+			v4 = Target{Name: host, IP: ip, Family: family}
+		case family == icmp.IPv6 && v6.IP == nil:
+			v6 = Target{Name: host, IP: ip, Family: family}
+		}
+	}
+	if v4.IP == nil || v6.IP == nil {
+		return v4, v6, fmt.Errorf("%s does not have both an IPv4 and an IPv6 address", host)
+	}
+	return v4, v6, nil
+}
+
+func getFamily(ip net.IP) icmp.Transport {
+	if ip.To4() != nil {
+		return icmp.IPv4
+	}
+	return icmp.IPv6
+}
@@ -0,0 +1,118 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/clambin/pinger/pkg/ping"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Tracer discovers the path to a target and then continuously pings all its hops.
+// A Tracer can be restarted against a new target: this stops all pingers of the previous trace before starting the new one.
+type Tracer struct {
+	Path   *Path
+	Socket ping.Socket
+	// Events, if set, records the events of the trace: discovered hops, probe timeouts during discovery and replies.
+	Events  *EventLog
+	Logger  *slog.Logger
+	Options PingOptions
+	MaxTTL  uint8
+	// ProbesPerTTL is the maximum number of probes Discover sends for each TTL. If zero, it sends up to 3.
+	ProbesPerTTL int
+	// ProbeInterval is the time between two probes sent by Discover. If zero, it's 50ms.
+	ProbeInterval time.Duration
+	// RateLimit, if set, caps the number of probes per second sent by the trace, during both discovery and pinging.
+	// This keeps the trace from tripping the ICMP rate limiting of routers, which shows up as false loss. It trades
+	// responsiveness for accuracy: discovery takes longer and, if the limit is lower than the rate at which the hops are
+	// pinged, pings are sent later than their interval and replies are read with a delay, inflating their latency.
+	RateLimit int
+	// DiscoveryTimeout, if set, bounds the time spent discovering the path, including the wait for late responses.
+	// Discover gives up after 5 seconds regardless (longer with a slower ProbeInterval), so DiscoveryTimeout can only
+	// shorten that wait.
+	DiscoveryTimeout time.Duration
+	// OnDiscoveryFailed, if set, is called when the path to the target could not be discovered.
+	OnDiscoveryFailed func(target Target, err error)
+	cancel            context.CancelFunc
+	done              chan struct{}
+	lock              sync.Mutex
+}
+
+// Start stops any running trace, clears the path and starts tracing the target. The trace runs until ctx is done,
+// or until Start or Stop is called.
+func (t *Tracer) Start(ctx context.Context, target Target) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stop()
+	t.Path.Reset()
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.done = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		l := t.Logger.With("target", target)
+		s := t.socket(ctx)
+		if err := t.discover(ctx, target, s, l); err != nil {
+			if ctx.Err() == nil {
+				l.Warn("path discovery failed", "err", err)
+				if t.OnDiscoveryFailed != nil {
+					t.OnDiscoveryFailed(target, err)
+				}
+			}
+			return
+		}
+		Ping(ctx, t.Path, eventSocket{Socket: s, events: t.Events}, t.Options, l)
+	}(t.done)
+}
+
+// Trace discovers the path to the target and then pings its hops for the given duration. It then waits, up to the ping
+// timeout, for the replies to the pings still outstanding, so they aren't counted as lost. Unlike Start, it blocks until
+// it's done and returns the error if the path could not be discovered. Trace must not be called while the tracer is
+// started.
+func (t *Tracer) Trace(ctx context.Context, target Target, duration time.Duration) error {
+	t.Path.Reset()
+	l := t.Logger.With("target", target)
+	s := t.socket(ctx)
+	if err := t.discover(ctx, target, s, l); err != nil {
+		return err
+	}
+	pingFor(ctx, t.Path, eventSocket{Socket: s, events: t.Events}, t.Options, duration, l)
+	return nil
+}
+
+// socket returns the socket to trace with, applying the tracer's RateLimit.
+func (t *Tracer) socket(ctx context.Context) ping.Socket {
+	if t.RateLimit > 0 {
+		return newRateLimitedSocket(ctx, t.Socket, t.RateLimit)
+	}
+	return t.Socket
+}
+
+func (t *Tracer) discover(ctx context.Context, target Target, s Socket, l *slog.Logger) error {
+	if t.DiscoveryTimeout <= 0 {
+		return Discover(ctx, t.Path, target, s, t.MaxTTL, t.ProbesPerTTL, t.ProbeInterval, t.Events, l)
+	}
+	discoveryCtx, cancel := context.WithTimeout(ctx, t.DiscoveryTimeout)
+	defer cancel()
+	err := Discover(discoveryCtx, t.Path, target, s, t.MaxTTL, t.ProbesPerTTL, t.ProbeInterval, t.Events, l)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("no path found to %s within %s", target, t.DiscoveryTimeout)
+	}
+	return err
+}
+
+// Stop stops the running trace and waits for it to end.
+func (t *Tracer) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stop()
+}
+
+func (t *Tracer) stop() {
+	if t.cancel != nil {
+		t.cancel()
+		<-t.done
+		t.cancel = nil
+	}
+}
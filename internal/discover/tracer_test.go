@@ -0,0 +1,230 @@
+package discover
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTracer_Start(t *testing.T) {
+	s := fakeSocket{routes: map[string][]net.IP{
+		"10.0.0.2": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+		"10.0.1.2": {net.ParseIP("10.0.1.1"), net.ParseIP("10.0.1.2")},
+	}}
+	var route Path
+	tracer := Tracer{
+		Path:    &route,
+		Socket:  &s,
+		Logger:  slog.Default(),
+		Options: PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracer.Start(ctx, Target{Name: "first", IP: net.ParseIP("10.0.0.2"), Family: icmp.IPv4})
+	assert.Eventually(t, func() bool { return s.Sent("10.0.0.1") > 2 }, time.Second, 10*time.Millisecond)
+	route.SetNote(0, "first path")
+
+	tracer.Start(ctx, Target{Name: "second", IP: net.ParseIP("10.0.1.2"), Family: icmp.IPv4})
+	// the new trace starts with an empty path and discovers the new route
+	assert.Eventually(t, func() bool { return s.Sent("10.0.1.1") > 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, route.Len())
+	assert.Equal(t, "10.0.1.1", route.Hops[0].String())
+	assert.Empty(t, route.Note(0))
+
+	// the pingers of the first trace have stopped
+	sent := s.Sent("10.0.0.1")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, sent, s.Sent("10.0.0.1"))
+
+	tracer.Stop()
+	// a pinger may still send one more ping before it notices that ctx is done
+	time.Sleep(20 * time.Millisecond)
+	sent = s.Sent("10.0.1.1")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, sent, s.Sent("10.0.1.1"))
+}
+
+func TestTracer_IPv6(t *testing.T) {
+	// link-local hops aren't covered: their replies carry no zone, so a real socket can't ping them.
+	// The fake socket doesn't need one, so it can't show that.
+	hops := []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8:1::1"), net.ParseIP("2001:db8:2::1")}
+	s := fakeSocket{routes: map[string][]net.IP{hops[2].String(): hops}}
+	var route Path
+	tracer := Tracer{
+		Path:    &route,
+		Socket:  &s,
+		Logger:  slog.Default(),
+		Options: PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+	}
+	tracer.Start(context.Background(), Target{IP: hops[2], Family: icmp.IPv6})
+	defer tracer.Stop()
+
+	assert.Eventually(t, func() bool {
+		if route.Len() != 3 || !route.complete(3) {
+			return false
+		}
+		for _, hop := range route.Hops {
+			if hop.Statistics().Received < 2 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+	for i, hop := range route.Hops {
+		assert.Equal(t, hops[i].String(), hop.String())
+	}
+}
+
+func TestTracer_PayloadSize(t *testing.T) {
+	// discovery probes and pings have the same size, so their latencies can be compared
+	s := payloadSocket{fakeSocket: &fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}}}
+	tracer := Tracer{
+		Path:    &Path{},
+		Socket:  &s,
+		Logger:  slog.Default(),
+		Options: PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+	}
+	tracer.Start(context.Background(), Target{IP: s.hops[1], Family: icmp.IPv4})
+	defer tracer.Stop()
+
+	assert.Eventually(t, func() bool { return s.Sent("10.0.0.1") > 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[uint8][]int{1: {payloadSize}, 2: {payloadSize}, 64: {payloadSize}}, s.Sizes())
+}
+
+// payloadSocket records the distinct payload sizes sent with each TTL.
+type payloadSocket struct {
+	*fakeSocket
+	sizes map[uint8][]int
+}
+
+func (s *payloadSocket) Ping(ip net.IP, seq icmp.SequenceNumber, ttl uint8, payload []byte) error {
+	s.lock.Lock()
+	if s.sizes == nil {
+		s.sizes = make(map[uint8][]int)
+	}
+	if !slices.Contains(s.sizes[ttl], len(payload)) {
+		s.sizes[ttl] = append(s.sizes[ttl], len(payload))
+	}
+	s.lock.Unlock()
+	return s.fakeSocket.Ping(ip, seq, ttl, payload)
+}
+
+func (s *payloadSocket) Sizes() map[uint8][]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return maps.Clone(s.sizes)
+}
+
+func TestTracer_Trace(t *testing.T) {
+	t.Run("discovery timeout", func(t *testing.T) {
+		tracer := Tracer{
+			Path:             &Path{},
+			Socket:           &silentSocket{},
+			Logger:           slog.Default(),
+			Options:          PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+			DiscoveryTimeout: 100 * time.Millisecond,
+		}
+		start := time.Now()
+		err := tracer.Trace(context.Background(), Target{IP: net.ParseIP("192.0.2.1"), Family: icmp.IPv4}, time.Second)
+		assert.EqualError(t, err, "no path found to 192.0.2.1 within 100ms")
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("silent hop", func(t *testing.T) {
+		// the target answers quickly, but the discovery timeout expires while waiting for the silent hop
+		s := lossySocket{
+			fakeSocket: &fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}},
+			lose:       map[uint8]int{2: 3},
+		}
+		tracer := Tracer{
+			Path:             &Path{},
+			Socket:           &s,
+			Logger:           slog.Default(),
+			Options:          PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+			DiscoveryTimeout: 500 * time.Millisecond,
+		}
+		require.NoError(t, tracer.Trace(context.Background(), Target{IP: s.hops[2], Family: icmp.IPv4}, 100*time.Millisecond))
+		require.Equal(t, 3, tracer.Path.Len())
+		assert.Nil(t, tracer.Path.Hops[1])
+		assert.NotZero(t, tracer.Path.Hops[2].Statistics().Sent)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		const rateLimit = 20
+		s := fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1")}}
+		tracer := Tracer{
+			Path:      &Path{},
+			Socket:    &s,
+			Logger:    slog.Default(),
+			Options:   PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+			RateLimit: rateLimit,
+		}
+		start := time.Now()
+		require.NoError(t, tracer.Trace(context.Background(), Target{IP: s.hops[0], Family: icmp.IPv4}, 500*time.Millisecond))
+		elapsed := time.Since(start)
+		assert.Equal(t, 1, tracer.Path.Len())
+		assert.NotZero(t, s.Pings())
+		assert.LessOrEqual(t, s.Pings(), int(elapsed.Seconds()*rateLimit)+1)
+	})
+}
+
+func TestTracer_Trace_Outstanding(t *testing.T) {
+	// replies take longer than the time left after the last ping: Trace waits for them, rather than counting them as lost
+	s := fakeSocket{hops: []net.IP{net.ParseIP("10.0.0.1")}, delay: 100 * time.Millisecond}
+	tracer := Tracer{
+		Path:    &Path{},
+		Socket:  &s,
+		Logger:  slog.Default(),
+		Options: PingOptions{Interval: 50 * time.Millisecond, Timeout: time.Second},
+	}
+	require.NoError(t, tracer.Trace(context.Background(), Target{IP: s.hops[0], Family: icmp.IPv4}, 5*50*time.Millisecond+25*time.Millisecond))
+	statistics := tracer.Path.Hops[0].Statistics()
+	assert.NotZero(t, statistics.Sent)
+	assert.Equal(t, statistics.Sent, statistics.Received)
+}
+
+func TestTracer_DiscoveryFailed(t *testing.T) {
+	failed := make(chan error, 1)
+	tracer := Tracer{
+		Path:             &Path{},
+		Socket:           &silentSocket{},
+		Logger:           slog.Default(),
+		Options:          PingOptions{Interval: 10 * time.Millisecond, Timeout: time.Second},
+		MaxTTL:           3,
+		DiscoveryTimeout: 100 * time.Millisecond,
+		OnDiscoveryFailed: func(_ Target, err error) {
+			failed <- err
+		},
+	}
+	tracer.Start(context.Background(), Target{IP: net.ParseIP("192.0.2.1"), Family: icmp.IPv4})
+	defer tracer.Stop()
+
+	select {
+	case err := <-failed:
+		assert.EqualError(t, err, "no path found to 192.0.2.1 within 100ms")
+	case <-time.After(time.Second):
+		t.Fatal("OnDiscoveryFailed not called")
+	}
+}
+
+// silentSocket never receives a response.
+type silentSocket struct{}
+
+func (silentSocket) Ping(net.IP, icmp.SequenceNumber, uint8, []byte) error { return nil }
+
+func (silentSocket) Read(ctx context.Context) (icmp.Response, error) {
+	<-ctx.Done()
+	return icmp.Response{}, ctx.Err()
+}
+
+func (silentSocket) Resolve(string) (net.IP, error) { return nil, nil }
+
+func (silentSocket) Serve(context.Context) {}
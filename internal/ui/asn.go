@@ -0,0 +1,31 @@
+package ui
+
+import "time"
+
+// ASNLookup looks up the autonomous system (AS) that announces an address, e.g. over DNS or in an offline database.
+type ASNLookup interface {
+	LookupASN(addr string) (string, error)
+}
+
+// ASNResolver looks up the AS of the hops' addresses in the background and caches the results, like NameResolver does for host names.
+// A nil ASNResolver doesn't look up any addresses.
+type ASNResolver struct {
+	cache *NameResolver
+}
+
+// NewASNResolver returns an ASNResolver that looks up addresses with lookup and caches the results for ttl.
+func NewASNResolver(lookup ASNLookup, ttl time.Duration) *ASNResolver {
+	return &ASNResolver{cache: newNameResolver(func(addr string) ([]string, error) {
+		asn, err := lookup.LookupASN(addr)
+		return []string{asn}, err
+	}, ttl)}
+}
+
+// ASN returns the cached AS of addr, without blocking. If addr isn't cached, or its entry expired,
+// ASN looks it up in the background and returns the cached AS (if any) in the meantime.
+func (r *ASNResolver) ASN(addr string) string {
+	if r == nil {
+		return ""
+	}
+	return r.cache.Name(addr)
+}
@@ -0,0 +1,27 @@
+package ui
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type stubASNLookup map[string]string
+
+func (s stubASNLookup) LookupASN(addr string) (string, error) {
+	if asn, ok := s[addr]; ok {
+		return asn, nil
+	}
+	return "", errors.New("not found")
+}
+
+func TestASNResolver_ASN(t *testing.T) {
+	r := NewASNResolver(stubASNLookup{"1.1.1.1": "AS13335 CLOUDFLARENET"}, time.Hour)
+	assert.Eventually(t, func() bool { return r.ASN("1.1.1.1") == "AS13335 CLOUDFLARENET" }, time.Second, time.Millisecond)
+	assert.Empty(t, r.ASN("192.0.2.1"))
+
+	// without a lookup, the AS is left blank
+	var nilResolver *ASNResolver
+	assert.Empty(t, nilResolver.ASN("1.1.1.1"))
+}
@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Column is a column of the path table that can be shown or hidden.
+type Column string
+
+const (
+	ColumnHop     Column = "hop"
+	ColumnAddr    Column = "addr"
+	ColumnName    Column = "name"
+	ColumnASN     Column = "asn"
+	ColumnSent    Column = "sent"
+	ColumnRcvd    Column = "rcvd"
+	ColumnLatency Column = "latency"
+	ColumnLoss    Column = "loss"
+	ColumnNote    Column = "note"
+
+	// the latency and loss columns are followed by a gradient, which is shown or hidden with them.
+	columnLatencyGradient Column = "latency gradient"
+	columnLossGradient    Column = "loss gradient"
+)
+
+// Columns lists all columns that can be shown.
+var Columns = []Column{ColumnHop, ColumnAddr, ColumnName, ColumnASN, ColumnSent, ColumnRcvd, ColumnLatency, ColumnLoss, ColumnNote}
+
+// DefaultColumns lists the columns shown by default, in their default order.
+var DefaultColumns = []Column{ColumnHop, ColumnAddr, ColumnName, ColumnSent, ColumnRcvd, ColumnLatency, ColumnLoss, ColumnNote}
+
+// ParseColumns parses a comma-separated list of column names, e.g. "hop,addr,loss".
+func ParseColumns(s string) ([]Column, error) {
+	var columns []Column
+	for _, name := range strings.Split(s, ",") {
+		column := Column(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(Columns, column) {
+			return nil, fmt.Errorf("invalid column %q", name)
+		}
+		if slices.Contains(columns, column) {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// header returns the column's header.
+func (c Column) header() string {
+	if c == columnLatencyGradient || c == columnLossGradient {
+		return ""
+	}
+	return string(c)
+}
+
+// withGradients adds the gradient columns after the latency and loss columns.
+func withGradients(columns []Column) []Column {
+	expanded := make([]Column, 0, len(columns)+2)
+	for _, column := range columns {
+		expanded = append(expanded, column)
+		switch column {
+		case ColumnLatency:
+			expanded = append(expanded, columnLatencyGradient)
+		case ColumnLoss:
+			expanded = append(expanded, columnLossGradient)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,30 @@
+package ui
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Column
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{name: "all", input: "hop,addr,name,sent,rcvd,latency,loss,note", want: DefaultColumns, wantErr: assert.NoError},
+		{name: "reordered", input: "loss, Addr", want: []Column{ColumnLoss, ColumnAddr}, wantErr: assert.NoError},
+		{name: "optional", input: "hop,addr,asn", want: []Column{ColumnHop, ColumnAddr, ColumnASN}, wantErr: assert.NoError},
+		{name: "unknown", input: "hop,jitter", wantErr: assert.Error},
+		{name: "gradient", input: "latency gradient", wantErr: assert.Error},
+		{name: "duplicate", input: "hop,hop", wantErr: assert.Error},
+		{name: "empty", input: "", wantErr: assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := ParseColumns(tt.input)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, columns)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package ui
+
+import (
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/vizroute/internal/discover"
+)
+
+// compareSummary reports which of the IPv4 and IPv6 paths is healthier, based on the statistics of their destination.
+// The path with the lowest loss wins. If both have the same loss, the one with the lowest latency wins.
+//
+// If the destination isn't pinged (-skip-target), the statistics of the last hop leading to it are used instead.
+func compareSummary(v4, v6 *discover.Path) string {
+	s4, ok4 := destinationStatistics(v4)
+	s6, ok6 := destinationStatistics(v6)
+	switch {
+	case !ok4 && !ok6:
+		return "waiting for both paths to reach the target"
+	case !ok4:
+		return "ipv6 is healthier: ipv4 has not reached the target"
+	case !ok6:
+		return "ipv4 is healthier: ipv6 has not reached the target"
+	}
+	l4, l6 := loss(s4), loss(s6)
+	switch {
+	case l4 < l6:
+		return "ipv4 is healthier: lower loss"
+	case l6 < l4:
+		return "ipv6 is healthier: lower loss"
+	case s4.Latency < s6.Latency:
+		return "ipv4 is healthier: lower latency"
+	case s6.Latency < s4.Latency:
+		return "ipv6 is healthier: lower latency"
+	default:
+		return "ipv4 and ipv6 are equally healthy"
+	}
+}
+
+func destinationStatistics(path *discover.Path) (ping.Statistics, bool) {
+	stats := getHopStatistics(path)
+	i := lastPinged(stats)
+	if i < 0 {
+		return ping.Statistics{}, false
+	}
+	return stats[i].Statistics, true
+}
+
+func loss(s ping.Statistics) float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return 1 - float64(s.Received)/float64(s.Sent)
+}
@@ -0,0 +1,78 @@
+package ui
+
+import (
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/clambin/vizroute/internal/discover"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompareSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		v4   *discover.Path
+		v6   *discover.Path
+		want string
+	}{
+		{
+			name: "no paths",
+			v4:   &discover.Path{},
+			v6:   &discover.Path{},
+			want: "waiting for both paths to reach the target",
+		},
+		{
+			name: "ipv6 not reached",
+			v4:   makePath("192.0.2.1", 2, 2, 0),
+			v6:   &discover.Path{},
+			want: "ipv4 is healthier: ipv6 has not reached the target",
+		},
+		{
+			name: "ipv6 has lower loss",
+			v4:   makePath("192.0.2.1", 2, 1, 0),
+			v6:   makePath("2001:db8::1", 2, 2, 0),
+			want: "ipv6 is healthier: lower loss",
+		},
+		{
+			name: "ipv4 has lower latency",
+			v4:   makePath("192.0.2.1", 1, 1, 0),
+			v6:   makePath("2001:db8::1", 1, 1, 20*time.Millisecond),
+			want: "ipv4 is healthier: lower latency",
+		},
+		{
+			name: "targets not pinged",
+			v4:   addHop(makePath("192.0.2.1", 2, 2, 0), "192.0.2.2"),
+			v6:   addHop(makePath("2001:db8::1", 2, 1, 0), "2001:db8::2"),
+			want: "ipv4 is healthier: lower loss",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, compareSummary(tt.v4, tt.v6))
+		})
+	}
+}
+
+func makePath(ip string, sent, received int, latency time.Duration) *discover.Path {
+	var path discover.Path
+	path.AddHop()
+	h := ping.Target{IP: net.ParseIP(ip)}
+	for i := range sent {
+		h.Sent(icmp.SequenceNumber(i + 1))
+	}
+	time.Sleep(latency)
+	for i := range received {
+		h.Received(true, icmp.SequenceNumber(i+1))
+	}
+	path.SetHop(0, &h)
+	return &path
+}
+
+// addHop adds a hop that isn't pinged to the path, like the destination with -skip-target.
+func addHop(path *discover.Path, ip string) *discover.Path {
+	path.AddHop()
+	path.SetHop(path.Len()-1, &ping.Target{IP: net.ParseIP(ip)})
+	return path
+}
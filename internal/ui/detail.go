@@ -0,0 +1,88 @@
+package ui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	mainPage    = "main"
+	detailsPage = "details"
+)
+
+// details is a popup showing the statistics of one hop of a table.
+type details struct {
+	*tview.TextView
+	table *RefreshingTable
+	hop   int
+}
+
+// showDetails opens a popup with the statistics of the hop shown in the given row of the table. Esc or Enter closes it.
+func (u *UI) showDetails(table *RefreshingTable, row int) {
+	hop := row - 1
+	if hop < 0 || hop >= table.Path.Len() {
+		return
+	}
+	u.details = &details{TextView: tview.NewTextView(), table: table, hop: hop}
+	u.details.SetDynamicColors(false).
+		SetBorder(true).
+		SetBorderPadding(0, 0, 1, 1).
+		SetTitle(" hop " + strconv.Itoa(row) + " ")
+	u.details.SetDoneFunc(func(tcell.Key) {
+		u.details = nil
+		u.Root.RemovePage(detailsPage)
+	})
+	u.details.refresh()
+	u.Root.AddPage(detailsPage, centered(u.details, 50, 12), true, true)
+}
+
+// refresh updates the popup with the hop's current statistics.
+func (d *details) refresh() {
+	stats := getHopStatistics(d.table.Path)
+	if d.hop >= len(stats) {
+		d.SetText("hop no longer in path")
+		return
+	}
+	lines := [][2]string{{"note", d.table.Path.Note(d.hop)}}
+	if hop := stats[d.hop]; hop != nil {
+		var lossText, latency string
+		if hop.Sent > 0 {
+			lossText = d.table.Format.Loss(loss(hop.Statistics))
+			if likelyRateLimited(stats)[d.hop] {
+				lossText += " (likely icmp rate limiting)"
+			}
+		}
+		if hop.Latency > 0 {
+			latency = d.table.Format.Latency(hop.Latency)
+		}
+		addr := hop.addr.String()
+		if slices.ContainsFunc(d.table.Path.Loops(), hop.addr.Equal) {
+			addr += " (routing loop suspected)"
+		}
+		lines = [][2]string{
+			{"addr", addr},
+			{"name", d.table.Names.Name(hop.addr.String())},
+			{"sent", strconv.Itoa(hop.Sent)},
+			{"rcvd", strconv.Itoa(hop.Received)},
+			{"loss", lossText},
+			{"latency", latency},
+			lines[0],
+		}
+	}
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(strings.TrimSpace(line[0]+":"+strings.Repeat(" ", 9-len(line[0]))+line[1]) + "\n")
+	}
+	d.SetText(text.String())
+}
+
+// centered returns a layout that shows p in the middle of the screen, with the given width and height.
+func centered(p tview.Primitive, width, height int) tview.Primitive {
+	return tview.NewGrid().
+		SetColumns(0, width, 0).
+		SetRows(0, height, 0).
+		AddItem(p, 1, 1, 1, 1, 0, 0, true)
+}
@@ -0,0 +1,48 @@
+package ui
+
+import (
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/clambin/vizroute/internal/discover"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestUI_ShowDetails(t *testing.T) {
+	var path discover.Path
+	h := ping.Target{IP: net.ParseIP("192.168.0.1")}
+	h.Sent(icmp.SequenceNumber(1))
+	h.Received(true, icmp.SequenceNumber(1))
+	h.Sent(icmp.SequenceNumber(2))
+	path.AddHop()
+	path.SetHop(0, &h)
+	path.SetNote(0, "home router")
+	tui := New(discover.Target{Name: "example.com"}, &path, false)
+	tui.SetNameResolver(nil)
+
+	// Enter on the selected row opens the hop's details
+	focus := func(tview.Primitive) {}
+	tui.RefreshingTable.InputHandler()(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), focus)
+	front, _ := tui.Root.GetFrontPage()
+	require.Equal(t, detailsPage, front)
+	require.NotNil(t, tui.details)
+	assert.Equal(t, " hop 1 ", tui.details.GetTitle())
+	assert.Equal(t, `addr:     192.168.0.1
+name:
+sent:     2
+rcvd:     1
+loss:     50.0%
+latency:  0.0ms
+note:     home router
+`, tui.details.GetText(false))
+
+	// Esc closes them
+	tui.details.InputHandler()(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone), focus)
+	front, _ = tui.Root.GetFrontPage()
+	assert.Equal(t, mainPage, front)
+	assert.Nil(t, tui.details)
+}
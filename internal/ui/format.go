@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"strconv"
+	"time"
+)
+
+// Format determines how latency and loss are rendered. All front-ends use it, so they render values identically.
+type Format struct {
+	// LatencyUnit is the unit in which latency is shown: time.Second, time.Millisecond or time.Microsecond.
+	LatencyUnit time.Duration
+	// Precision is the number of decimal places.
+	Precision int
+	// Blocks renders gradients as unicode blocks (see GradientBlocks) rather than ASCII (see Gradient).
+	Blocks bool
+	// LogScale scales latency bars logarithmically, so a single slow hop doesn't flatten the others' bars.
+	LogScale bool
+}
+
+var DefaultFormat = Format{LatencyUnit: time.Millisecond, Precision: 1}
+
+var unitSuffix = map[time.Duration]string{
+	time.Second:      "s",
+	time.Millisecond: "ms",
+	time.Microsecond: "µs",
+}
+
+// Latency formats a latency in the configured unit, e.g. "12.3ms".
+func (f Format) Latency(latency time.Duration) string {
+	unit, suffix := f.LatencyUnit, unitSuffix[f.LatencyUnit]
+	if suffix == "" {
+		unit, suffix = time.Millisecond, unitSuffix[time.Millisecond]
+	}
+	return strconv.FormatFloat(float64(latency)/float64(unit), 'f', f.Precision, 64) + suffix
+}
+
+// Loss formats a loss ratio (0 to 1) as a percentage, e.g. "12.5%".
+func (f Format) Loss(loss float64) string {
+	return strconv.FormatFloat(100*loss, 'f', f.Precision, 64) + "%"
+}
+
+// Gradient renders value as a bar of length characters, relative to maximum.
+func (f Format) Gradient(value, maximum float64, length int) string {
+	if f.Blocks {
+		return GradientBlocks(value, maximum, length)
+	}
+	return Gradient(value, maximum, length)
+}
+
+// LatencyGradient renders latency as a bar of length characters, relative to the highest latency of the path.
+func (f Format) LatencyGradient(latency, maxLatency time.Duration, length int) string {
+	value, maximum := latency.Seconds(), maxLatency.Seconds()
+	if f.LogScale {
+		value, maximum = logScale(value, maximum), 1
+	}
+	return f.Gradient(value, maximum, length)
+}
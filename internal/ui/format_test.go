@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      Format
+		latency     time.Duration
+		loss        float64
+		wantLatency string
+		wantLoss    string
+	}{
+		{
+			name:        "default",
+			format:      DefaultFormat,
+			latency:     12345 * time.Microsecond,
+			loss:        0.125,
+			wantLatency: "12.3ms",
+			wantLoss:    "12.5%",
+		},
+		{
+			name:        "precision",
+			format:      Format{LatencyUnit: time.Millisecond, Precision: 3},
+			latency:     12345 * time.Microsecond,
+			loss:        1.0 / 3,
+			wantLatency: "12.345ms",
+			wantLoss:    "33.333%",
+		},
+		{
+			name:        "microseconds",
+			format:      Format{LatencyUnit: time.Microsecond, Precision: 0},
+			latency:     12345 * time.Microsecond,
+			wantLatency: "12345µs",
+			wantLoss:    "0%",
+		},
+		{
+			name:        "seconds",
+			format:      Format{LatencyUnit: time.Second, Precision: 2},
+			latency:     1500 * time.Millisecond,
+			loss:        1,
+			wantLatency: "1.50s",
+			wantLoss:    "100.00%",
+		},
+		{
+			name:        "unsupported unit",
+			format:      Format{LatencyUnit: time.Minute, Precision: 1},
+			latency:     time.Millisecond,
+			wantLatency: "1.0ms",
+			wantLoss:    "0.0%",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantLatency, tt.format.Latency(tt.latency))
+			assert.Equal(t, tt.wantLoss, tt.format.Loss(tt.loss))
+		})
+	}
+}
+
+func TestFormat_LatencyGradient(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		latency time.Duration
+		want    string
+	}{
+		{name: "linear", format: Format{}, latency: time.Millisecond, want: "|*---------|"},
+		{name: "log: zero", format: Format{LogScale: true}, latency: 0, want: "|----------|"},
+		{name: "log: maximum", format: Format{LogScale: true}, latency: 10 * time.Millisecond, want: "|**********|"},
+		{name: "log: mid", format: Format{LogScale: true}, latency: time.Millisecond, want: "|*******---|"},
+		{name: "log: small", format: Format{LogScale: true}, latency: 5 * time.Microsecond, want: "|*---------|"},
+		{name: "log: blocks", format: Format{LogScale: true, Blocks: true}, latency: time.Millisecond, want: "████████▏   "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.format.LatencyGradient(tt.latency, 10*time.Millisecond, 12))
+		})
+	}
+}
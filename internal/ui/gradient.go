@@ -21,3 +21,36 @@ func Gradient(value float64, maximum float64, length int) string {
 	output.WriteRune('|')
 	return output.String()
 }
+
+// logDecades is the number of decades logScale spreads over a bar: a value 1000 times smaller than the maximum
+// still fills a tenth of the bar, rather than nothing.
+const logDecades = 3
+
+// logScale maps value in [0, maximum] logarithmically to [0, 1], so values that differ by orders of magnitude can all be
+// told apart in a gradient.
+func logScale(value float64, maximum float64) float64 {
+	if value <= 0 || maximum <= 0 {
+		return 0
+	}
+	k := math.Pow(10, logDecades) - 1
+	return math.Log1p(k*min(value, maximum)/maximum) / math.Log1p(k)
+}
+
+// blocks holds the left-aligned blocks that fill 1/8 to 8/8 of a character cell.
+var blocks = []rune("▏▎▍▌▋▊▉█")
+
+// GradientBlocks renders value as a bar of unicode blocks, relative to maximum. Partial blocks give the bar a
+// resolution of 1/8 of a character. The bar is always length characters long.
+func GradientBlocks(value float64, maximum float64, length int) string {
+	eighths := 0
+	if value > 0 && maximum > 0 {
+		eighths = min(8*length, int(math.Ceil(8*float64(length)*value/maximum)))
+	}
+	var output strings.Builder
+	output.WriteString(strings.Repeat(string(blocks[7]), eighths/8))
+	if eighths%8 > 0 {
+		output.WriteRune(blocks[eighths%8-1])
+	}
+	output.WriteString(strings.Repeat(" ", length-(eighths+7)/8))
+	return output.String()
+}
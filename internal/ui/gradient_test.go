@@ -3,6 +3,7 @@ package ui
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestGradient(t *testing.T) {
@@ -43,3 +44,26 @@ func TestGradient_length(t *testing.T) {
 	g := Gradient(0.02656511111, 0.026565, 12)
 	assert.Len(t, g, 12)
 }
+
+func TestGradientBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   float64
+		maximum float64
+		want    string
+	}{
+		{name: "minimum", value: 0, maximum: 10, want: "    "},
+		{name: "maximum", value: 10, maximum: 10, want: "████"},
+		{name: "partial", value: 5.5, maximum: 10, want: "██▎ "},
+		{name: "ceiling", value: 0.01, maximum: 10, want: "▏   "},
+		{name: "above maximum", value: 20, maximum: 10, want: "████"},
+		{name: "no maximum", value: 1, maximum: 0, want: "    "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := GradientBlocks(tt.value, tt.maximum, 4)
+			assert.Equal(t, tt.want, g)
+			assert.Equal(t, 4, utf8.RuneCountInString(g))
+		})
+	}
+}
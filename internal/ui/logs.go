@@ -0,0 +1,89 @@
+package ui
+
+import (
+	"bytes"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"strings"
+	"sync"
+)
+
+// maxLogLines is the number of log lines a LogViewer keeps, so it can show them again when its filter changes.
+const maxLogLines = 1000
+
+// LogViewer shows the log output written to it. A filter, entered with '/', limits the output to the lines containing it.
+type LogViewer struct {
+	*tview.TextView
+	lines   []string
+	partial []byte
+	filter  string
+	prompt  *prompt
+	lock    sync.Mutex
+}
+
+func newLogViewer() *LogViewer {
+	v := LogViewer{TextView: tview.NewTextView()}
+	v.TextView.SetBorder(true).SetTitle("logs").SetTitleAlign(tview.AlignLeft)
+	v.TextView.SetScrollable(true).ScrollToEnd()
+	return &v
+}
+
+// Write adds the complete lines in p to the log and shows the ones that match the filter.
+func (v *LogViewer) Write(p []byte) (int, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.partial = append(v.partial, p...)
+	for {
+		i := bytes.IndexByte(v.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(v.partial[:i+1])
+		v.partial = v.partial[i+1:]
+		v.lines = append(v.lines, line)
+		if len(v.lines) > maxLogLines {
+			v.lines = v.lines[len(v.lines)-maxLogLines:]
+		}
+		if strings.Contains(line, v.filter) {
+			_, _ = v.TextView.Write([]byte(line))
+		}
+	}
+	return len(p), nil
+}
+
+// SetFilter shows only the lines that contain filter. An empty filter shows all lines.
+func (v *LogViewer) SetFilter(filter string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.filter = filter
+	v.TextView.Clear()
+	for _, line := range v.lines {
+		if strings.Contains(line, filter) {
+			_, _ = v.TextView.Write([]byte(line))
+		}
+	}
+	v.TextView.ScrollToEnd()
+	v.TextView.SetTitle(v.defaultTitle())
+}
+
+func (v *LogViewer) defaultTitle() string {
+	if v.filter == "" {
+		return "logs"
+	}
+	return "logs - filter: " + v.filter
+}
+
+// startFilterPrompt opens a prompt in the viewer's title to enter a new filter.
+func (v *LogViewer) startFilterPrompt() {
+	v.prompt = &prompt{label: "filter", text: []rune(v.filter), submit: v.SetFilter}
+	v.TextView.SetTitle(v.prompt.title())
+}
+
+func (v *LogViewer) handlePromptInput(event *tcell.EventKey) {
+	if v.prompt.handleInput(event) {
+		v.prompt = nil
+		v.TextView.SetTitle(v.defaultTitle())
+	} else {
+		v.TextView.SetTitle(v.prompt.title())
+	}
+}
@@ -0,0 +1,43 @@
+package ui
+
+import (
+	"github.com/clambin/vizroute/internal/discover"
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLogViewer_Filter(t *testing.T) {
+	tui := New(discover.Target{Name: "example.com"}, &discover.Path{}, true)
+	v := tui.LogViewer
+
+	_, _ = v.Write([]byte("level=INFO msg=\"hop discovered\"\nlevel=WARN msg=\"read failed\"\nlevel=INFO msg="))
+	assert.Equal(t, "level=INFO msg=\"hop discovered\"\nlevel=WARN msg=\"read failed\"\n", v.GetText(false))
+
+	// '/' opens the filter prompt
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, '/', tcell.ModNone)}
+	for _, r := range "WARN" {
+		keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	for _, key := range keys {
+		assert.Nil(t, tui.handleInput(key))
+	}
+	assert.Equal(t, " filter: WARN_ ", v.GetTitle())
+	assert.Nil(t, tui.handleInput(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)))
+	assert.Equal(t, "logs - filter: WARN", v.GetTitle())
+	assert.Equal(t, "level=WARN msg=\"read failed\"\n", v.GetText(false))
+
+	// new lines are filtered too
+	_, _ = v.Write([]byte("\"target reached\"\nlevel=WARN msg=\"timeout\"\n"))
+	assert.Equal(t, "level=WARN msg=\"read failed\"\nlevel=WARN msg=\"timeout\"\n", v.GetText(false))
+
+	// clearing the filter shows all lines again
+	v.SetFilter("")
+	assert.Equal(t, "logs", v.GetTitle())
+	assert.Equal(t, "level=INFO msg=\"hop discovered\"\nlevel=WARN msg=\"read failed\"\nlevel=INFO msg=\"target reached\"\nlevel=WARN msg=\"timeout\"\n", v.GetText(false))
+
+	// '/' is passed on while the table is prompting for input
+	tui.RefreshingTable.startPrompt("note", "", func(string) {})
+	key := tcell.NewEventKey(tcell.KeyRune, '/', tcell.ModNone)
+	assert.Equal(t, key, tui.handleInput(key))
+}
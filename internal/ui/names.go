@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// NameResolver resolves addresses to host names and caches the results, including failed lookups, for a configurable time.
+// A nil NameResolver doesn't resolve any addresses.
+type NameResolver struct {
+	lookup  func(string) ([]string, error)
+	entries map[string]*nameEntry
+	ttl     time.Duration
+	lock    sync.Mutex
+}
+
+type nameEntry struct {
+	expires   time.Time
+	name      string
+	resolving bool
+}
+
+// NewNameResolver returns a NameResolver that caches names for ttl.
+func NewNameResolver(ttl time.Duration) *NameResolver {
+	return newNameResolver(net.LookupAddr, ttl)
+}
+
+func newNameResolver(lookup func(string) ([]string, error), ttl time.Duration) *NameResolver {
+	return &NameResolver{
+		lookup:  lookup,
+		entries: make(map[string]*nameEntry),
+		ttl:     ttl,
+	}
+}
+
+// Name returns the cached name of addr, without blocking. If addr isn't cached, or its entry expired,
+// Name looks it up in the background and returns the cached name (if any) in the meantime.
+func (r *NameResolver) Name(addr string) string {
+	if r == nil {
+		return ""
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	e, ok := r.entries[addr]
+	if !ok {
+		e = &nameEntry{}
+		r.entries[addr] = e
+	}
+	if !e.resolving && time.Now().After(e.expires) {
+		e.resolving = true
+		go func() {
+			name := r.lookupName(addr)
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			e.name, e.expires, e.resolving = name, time.Now().Add(r.ttl), false
+		}()
+	}
+	return e.name
+}
+
+// Resolve returns the name of addr. If addr isn't cached, or its entry expired, Resolve looks it up and waits for the result.
+func (r *NameResolver) Resolve(addr string) string {
+	if r == nil {
+		return ""
+	}
+	r.lock.Lock()
+	if e, ok := r.entries[addr]; ok && time.Now().Before(e.expires) {
+		r.lock.Unlock()
+		return e.name
+	}
+	r.lock.Unlock()
+
+	name := r.lookupName(addr)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[addr] = &nameEntry{name: name, expires: time.Now().Add(r.ttl)}
+	return name
+}
+
+func (r *NameResolver) lookupName(addr string) string {
+	names, err := r.lookup(addr)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
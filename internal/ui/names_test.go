@@ -0,0 +1,54 @@
+package ui
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNameResolver_Name(t *testing.T) {
+	var lookups atomic.Int32
+	r := newNameResolver(func(addr string) ([]string, error) {
+		lookups.Add(1)
+		if addr == "192.168.0.1" {
+			return []string{"router.lan."}, nil
+		}
+		return nil, errors.New("not found")
+	}, time.Hour)
+
+	// the first call starts the lookup in the background
+	assert.Empty(t, r.Name("192.168.0.1"))
+	assert.Eventually(t, func() bool { return r.Name("192.168.0.1") == "router.lan." }, time.Second, time.Millisecond)
+
+	// failed lookups are cached too
+	assert.Empty(t, r.Name("192.168.0.2"))
+	assert.Eventually(t, func() bool { return lookups.Load() == 2 }, time.Second, time.Millisecond)
+	for range 10 {
+		assert.Empty(t, r.Name("192.168.0.2"))
+		assert.Equal(t, "router.lan.", r.Name("192.168.0.1"))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(2), lookups.Load())
+
+	var nilResolver *NameResolver
+	assert.Empty(t, nilResolver.Name("192.168.0.1"))
+}
+
+func TestNameResolver_Resolve(t *testing.T) {
+	var lookups atomic.Int32
+	r := newNameResolver(func(string) ([]string, error) {
+		lookups.Add(1)
+		return []string{"router.lan."}, nil
+	}, 50*time.Millisecond)
+
+	assert.Equal(t, "router.lan.", r.Resolve("192.168.0.1"))
+	assert.Equal(t, "router.lan.", r.Resolve("192.168.0.1"))
+	assert.Equal(t, int32(1), lookups.Load())
+
+	// expired entries are looked up again
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "router.lan.", r.Resolve("192.168.0.1"))
+	assert.Equal(t, int32(2), lookups.Load())
+}
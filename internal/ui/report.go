@@ -0,0 +1,65 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/clambin/vizroute/internal/discover"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteReport writes the statistics of each hop of the path to w, as a plain-text table.
+// Names resolves the hops' addresses to host names. If nil, names are not shown.
+// If the path suggests a routing loop, the report starts with a warning and the looping addresses are marked.
+func WriteReport(w io.Writer, target discover.Target, path *discover.Path, format Format, names *NameResolver) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "traceroute: %s\n", target)
+	loops := path.Loops()
+	if len(loops) > 0 {
+		_, _ = fmt.Fprintln(tw, loopWarning(loops))
+	}
+	_, _ = fmt.Fprintln(tw, "hop\taddr\tname\tloss\tsent\trcvd\tlatency\tnote\t")
+	for i, hop := range getHopStatistics(path) {
+		row := []string{strconv.Itoa(i + 1), "", "", "", "", "", "", path.Note(i)}
+		if hop != nil {
+			row[1] = hop.addr.String()
+			if slices.ContainsFunc(loops, hop.addr.Equal) {
+				row[1] += " (loop)"
+			}
+			row[2] = names.Resolve(hop.addr.String())
+			row[4], row[5] = strconv.Itoa(hop.Sent), strconv.Itoa(hop.Received)
+			if hop.Sent > 0 {
+				row[3] = format.Loss(loss(hop.Statistics))
+			}
+			if hop.Received > 0 {
+				row[6] = format.Latency(hop.Latency)
+			}
+		}
+		for _, cell := range row {
+			_, _ = fmt.Fprint(tw, cell, "\t")
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// reportDir is the directory where reports are saved.
+var reportDir = "."
+
+// saveReport writes a report of the path to a file in dir, named after the time the report was taken. It returns the file's name.
+func saveReport(dir string, now time.Time, target discover.Target, path *discover.Path, format Format, names *NameResolver) (string, error) {
+	filename := filepath.Join(dir, "vizroute-"+now.Format("20060102-150405")+".txt")
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	if err = WriteReport(f, target, path, format, names); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return filename, f.Close()
+}
@@ -0,0 +1,66 @@
+package ui
+
+import (
+	"errors"
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/clambin/vizroute/internal/discover"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteReport(t *testing.T) {
+	names := newNameResolver(func(addr string) ([]string, error) {
+		if addr == "192.168.0.1" {
+			return []string{"router.lan."}, nil
+		}
+		return nil, errors.New("not found")
+	}, time.Hour)
+
+	var path discover.Path
+	for range 3 {
+		path.AddHop()
+	}
+	router := ping.Target{IP: net.ParseIP("192.168.0.1")}
+	router.Sent(1)
+	router.Sent(2)
+	router.Received(true, 1)
+	path.SetHop(0, &router)
+	path.SetHop(2, &ping.Target{IP: net.ParseIP("192.0.2.1")})
+	path.SetNote(2, "destination")
+
+	var output strings.Builder
+	target := discover.Target{Name: "example.com", IP: net.ParseIP("192.0.2.1"), Family: icmp.IPv4}
+	require.NoError(t, WriteReport(&output, target, &path, DefaultFormat, names))
+
+	lines := strings.Split(output.String(), "\n")
+	require.Len(t, lines, 6)
+	assert.Equal(t, "traceroute: example.com (192.0.2.1)", strings.TrimSpace(lines[0]))
+	assert.Equal(t, []string{"hop", "addr", "name", "loss", "sent", "rcvd", "latency", "note"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"1", "192.168.0.1", "router.lan.", "50.0%", "2", "1"}, strings.Fields(lines[2])[:6])
+	assert.Equal(t, []string{"2"}, strings.Fields(lines[3]))
+	assert.Equal(t, []string{"3", "192.0.2.1", "0", "0", "destination"}, strings.Fields(lines[4]))
+}
+
+func TestWriteReport_Loop(t *testing.T) {
+	var path discover.Path
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.2"} {
+		path.AddHop()
+		path.SetHop(i, &ping.Target{IP: net.ParseIP(ip)})
+	}
+
+	var output strings.Builder
+	target := discover.Target{Name: "example.com", IP: net.ParseIP("192.0.2.1"), Family: icmp.IPv4}
+	require.NoError(t, WriteReport(&output, target, &path, DefaultFormat, nil))
+
+	lines := strings.Split(output.String(), "\n")
+	require.Len(t, lines, 8)
+	assert.Equal(t, "routing loop suspected: 10.0.0.2", strings.TrimSpace(lines[1]))
+	assert.Equal(t, []string{"1", "10.0.0.1", "0", "0"}, strings.Fields(lines[3]))
+	assert.Equal(t, []string{"2", "10.0.0.2", "(loop)", "0", "0"}, strings.Fields(lines[4]))
+	assert.Equal(t, []string{"4", "10.0.0.2", "(loop)", "0", "0"}, strings.Fields(lines[6]))
+}
@@ -1,8 +1,11 @@
 package ui
 
 import (
+	"fmt"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"maps"
+	"slices"
 )
 
 type theme struct {
@@ -10,15 +13,134 @@ type theme struct {
 	HeaderBgColor tcell.Color
 	CellFgColor   tcell.Color
 	CellBgColor   tcell.Color
+
+	RateLimitedFgColor tcell.Color
+	WarningFgColor     tcell.Color
+
+	// GoodFgColor, FairFgColor and BadFgColor color the latency and loss cells, depending on the Thresholds they exceed.
+	GoodFgColor tcell.Color
+	FairFgColor tcell.Color
+	BadFgColor  tcell.Color
+
+	BorderColor     tcell.Color
+	TitleColor      tcell.Color
+	TextColor       tcell.Color
+	BackgroundColor tcell.Color
+	// SelectedStyle is the style of the selected row. If zero, the row's colors are inverted.
+	SelectedStyle tcell.Style
 }
 
-var style = theme{
-	HeaderFgColor: tcell.ColorWhite,
-	HeaderBgColor: tcell.ColorBlack,
-	CellFgColor:   tcell.ColorSkyblue,
-	CellBgColor:   tcell.ColorBlack,
+var themes = map[string]theme{
+	"dark": {
+		HeaderFgColor: tcell.ColorWhite,
+		HeaderBgColor: tcell.ColorBlack,
+		CellFgColor:   tcell.ColorSkyblue,
+		CellBgColor:   tcell.ColorBlack,
+
+		RateLimitedFgColor: tcell.ColorGray,
+		WarningFgColor:     tcell.ColorRed,
+
+		GoodFgColor: tcell.ColorGreen,
+		FairFgColor: tcell.ColorYellow,
+		BadFgColor:  tcell.ColorRed,
+
+		BorderColor:     tcell.ColorSkyblue,
+		TitleColor:      tcell.ColorWhite,
+		TextColor:       tcell.ColorWhite,
+		BackgroundColor: tcell.ColorBlack,
+	},
+	"light": {
+		HeaderFgColor: tcell.ColorBlack,
+		HeaderBgColor: tcell.ColorLightGray,
+		CellFgColor:   tcell.ColorNavy,
+		CellBgColor:   tcell.ColorWhite,
+
+		RateLimitedFgColor: tcell.ColorGray,
+		WarningFgColor:     tcell.ColorMaroon,
+
+		GoodFgColor: tcell.ColorGreen,
+		FairFgColor: tcell.ColorOlive,
+		BadFgColor:  tcell.ColorMaroon,
+
+		BorderColor:     tcell.ColorNavy,
+		TitleColor:      tcell.ColorBlack,
+		TextColor:       tcell.ColorBlack,
+		BackgroundColor: tcell.ColorWhite,
+	},
+	// mono uses the terminal's own colors only
+	"mono": {
+		HeaderFgColor: tcell.ColorDefault,
+		HeaderBgColor: tcell.ColorDefault,
+		CellFgColor:   tcell.ColorDefault,
+		CellBgColor:   tcell.ColorDefault,
+
+		RateLimitedFgColor: tcell.ColorDefault,
+		WarningFgColor:     tcell.ColorDefault,
+
+		GoodFgColor: tcell.ColorDefault,
+		FairFgColor: tcell.ColorDefault,
+		BadFgColor:  tcell.ColorDefault,
+
+		BorderColor:     tcell.ColorDefault,
+		TitleColor:      tcell.ColorDefault,
+		TextColor:       tcell.ColorDefault,
+		BackgroundColor: tcell.ColorDefault,
+		SelectedStyle:   tcell.StyleDefault.Reverse(true),
+	},
 }
 
+var style theme
+
 func init() {
-	tview.Styles.BorderColor = tcell.ColorSkyblue
+	_ = SetTheme("dark")
+}
+
+// Themes returns the names of the available themes.
+func Themes() []string {
+	return slices.Sorted(maps.Keys(themes))
+}
+
+// SetTheme selects the colors of the UI. It must be called before the UI is created.
+func SetTheme(name string) error {
+	t, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("invalid theme %q", name)
+	}
+	style = t
+	tview.Styles.BorderColor = t.BorderColor
+	tview.Styles.TitleColor = t.TitleColor
+	tview.Styles.PrimaryTextColor = t.TextColor
+	tview.Styles.PrimitiveBackgroundColor = t.BackgroundColor
+	return nil
+}
+
+// Thresholds determine the color of the latency and loss cells. A value at or above the Fair threshold is shown in
+// the theme's FairFgColor, at or above the Bad threshold in its BadFgColor, and in its GoodFgColor otherwise.
+type Thresholds struct {
+	// LossFair and LossBad are fractions of the packets sent, e.g. 0.01 for 1%.
+	LossFair, LossBad float64
+	// LatencyFair and LatencyBad are fractions of the highest latency of the path.
+	LatencyFair, LatencyBad float64
+}
+
+var DefaultThresholds = Thresholds{LossFair: 0.01, LossBad: 0.05, LatencyFair: 0.5, LatencyBad: 0.8}
+
+func (t Thresholds) lossColor(loss float64) tcell.Color {
+	return thresholdColor(loss, t.LossFair, t.LossBad)
+}
+
+// latencyColor returns the color of a latency, given its ratio to the highest latency of the path.
+func (t Thresholds) latencyColor(ratio float64) tcell.Color {
+	return thresholdColor(ratio, t.LatencyFair, t.LatencyBad)
+}
+
+func thresholdColor(value, fair, bad float64) tcell.Color {
+	switch {
+	case value >= bad:
+		return style.BadFgColor
+	case value >= fair:
+		return style.FairFgColor
+	default:
+		return style.GoodFgColor
+	}
 }
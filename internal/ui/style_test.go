@@ -0,0 +1,23 @@
+package ui
+
+import (
+	"github.com/clambin/vizroute/internal/discover"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSetTheme(t *testing.T) {
+	t.Cleanup(func() { _ = SetTheme("dark") })
+
+	assert.Equal(t, []string{"dark", "light", "mono"}, Themes())
+	assert.Error(t, SetTheme("solarized"))
+
+	assert.NoError(t, SetTheme("mono"))
+	assert.Equal(t, tcell.ColorDefault, tview.Styles.BorderColor)
+	table := NewRefreshingTable(discover.Target{}, &discover.Path{})
+	fg, bg, _ := table.GetCell(0, 0).Style.Decompose()
+	assert.Equal(t, tcell.ColorDefault, fg)
+	assert.Equal(t, tcell.ColorDefault, bg)
+}
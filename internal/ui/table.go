@@ -3,56 +3,109 @@ package ui
 import (
 	"github.com/clambin/pinger/pkg/ping"
 	"github.com/clambin/vizroute/internal/discover"
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type RefreshingTable struct {
 	*tview.Table
 	*discover.Path
+	// OnTargetChange is called when the user enters a new target to trace.
+	OnTargetChange func(host string)
+	// Names resolves the hops' addresses to host names. If nil, names are not shown.
+	Names *NameResolver
+	// ASNs looks up the AS of the hops' addresses. If nil, the asn column is left blank.
+	ASNs   *ASNResolver
+	Format Format
+	// Thresholds determine the color of the latency and loss cells.
+	Thresholds Thresholds
+	prompt     *prompt
+	title      string
+	warning    string
+	problem    string
+	paused     bool
+	target     discover.Target
+	status     atomic.Pointer[status]
+	columns    []Column
+	addrs      []string
 }
 
-func NewRefreshingTable(target string, path *discover.Path) *RefreshingTable {
+func NewRefreshingTable(target discover.Target, path *discover.Path) *RefreshingTable {
 	table := RefreshingTable{
-		Table: tview.NewTable(),
-		Path:  path,
+		Table:      tview.NewTable(),
+		Path:       path,
+		Format:     DefaultFormat,
+		Thresholds: DefaultThresholds,
+		Names:      NewNameResolver(time.Hour),
+		columns:    withGradients(DefaultColumns),
 	}
 	table.Table.SetEvaluateAllRows(true).
 		SetFixed(1, 0).
 		SetSelectable(true, false).
+		SetSelectedStyle(style.SelectedStyle).
 		Select(1, 0).
 		SetBorder(true).
 		SetBorderPadding(0, 0, 1, 1)
-	table.Table.SetTitle(" traceroute: " + target + " ")
-	table.populateTable()
+	table.Table.SetInputCapture(table.handleInput)
+	table.SetTarget(target)
 	return &table
 }
 
+// SetTarget sets the target shown in the table's title and rebuilds the table from the path.
+func (t *RefreshingTable) SetTarget(target discover.Target) {
+	t.target = target
+	t.problem = ""
+	t.title = " traceroute: " + target.String() + " "
+	t.Table.SetTitle(t.title)
+	t.Table.Clear()
+	t.populateTable()
+}
+
+// SetColumns sets the columns shown in the table, in the order given, and rebuilds the table.
+func (t *RefreshingTable) SetColumns(columns []Column) {
+	t.columns = withGradients(columns)
+	t.Table.Clear()
+	t.populateTable()
+}
+
 func (t *RefreshingTable) populateTable() {
-	columns := []string{"hop", "addr", "name", "sent", "rcvd", "latency", "", "loss", ""}
-	for i, col := range columns {
-		t.SetCell(0, i, headerCell(col))
+	for i, col := range t.columns {
+		t.SetCell(0, i, headerCell(col.header()))
 	}
+	t.addrs = make([]string, len(t.Path.Hops))
 	for i, hop := range t.Path.Hops {
-		t.Table.SetCell(1+i, 0, rowCell(strconv.Itoa(i+1)).SetAlign(tview.AlignRight)) // hop
+		for c, col := range t.columns {
+			t.Table.SetCell(1+i, c, rowCell(""))
+			switch col {
+			case ColumnHop, ColumnSent, ColumnRcvd, ColumnLatency, ColumnLoss:
+				t.Table.GetCell(1+i, c).SetAlign(tview.AlignRight)
+			}
+		}
+		t.cell(1+i, ColumnHop).Text = strconv.Itoa(i + 1)
+		t.cell(1+i, ColumnNote).Text = t.Path.Note(i)
 		if hop == nil {
 			continue
 		}
-		t.Table.SetCell(i+1, 1, rowCell(hop.IP.String())) // addr
-		ipAddresses, err := net.LookupAddr(hop.IP.String())
-		if err != nil {
-			ipAddresses = []string{""}
-		}
-		t.Table.SetCell(i+1, 2, rowCell(ipAddresses[0]))                // name
-		t.Table.SetCell(i+1, 3, rowCell("").SetAlign(tview.AlignRight)) // sent
-		t.Table.SetCell(i+1, 4, rowCell("").SetAlign(tview.AlignRight)) // rcvd
-		t.Table.SetCell(i+1, 5, rowCell("").SetAlign(tview.AlignRight)) // latency
-		t.Table.SetCell(i+1, 6, rowCell(""))                            // latency gradient
-		t.Table.SetCell(i+1, 7, rowCell("").SetAlign(tview.AlignRight)) // loss
-		t.Table.SetCell(i+1, 8, rowCell(""))                            // loss gradient
+		t.addrs[i] = hop.IP.String()
+		t.cell(1+i, ColumnAddr).Text = hop.IP.String()
+		t.cell(1+i, ColumnName).Text = t.Names.Name(hop.IP.String())
+		t.cell(1+i, ColumnASN).Text = t.ASNs.ASN(hop.IP.String())
+	}
+}
+
+// cell returns the cell of the given column in the given row. If the column isn't shown,
+// it returns a cell that isn't part of the table, so setting its content has no effect.
+func (t *RefreshingTable) cell(row int, column Column) *tview.TableCell {
+	if c := slices.Index(t.columns, column); c >= 0 {
+		return t.Table.GetCell(row, c)
 	}
+	return &tview.TableCell{}
 }
 
 func headerCell(text string) *tview.TableCell {
@@ -63,31 +116,68 @@ func rowCell(text string) *tview.TableCell {
 	return tview.NewTableCell(text).SetTextColor(style.CellFgColor).SetBackgroundColor(style.CellBgColor)
 }
 
+// Refresh updates the table with the path's current hops and statistics. It does nothing while the table is paused.
 func (t *RefreshingTable) Refresh() {
-	if len(t.Path.Hops)+1 > t.Table.GetRowCount() {
+	if t.paused {
+		t.updateTitle()
+		return
+	}
+	if t.pathChanged() {
+		t.Table.Clear()
 		t.populateTable()
 	}
 	stats := getHopStatistics(t.Path)
 	maxLatency := getMaxLatency(stats)
+	rateLimited := likelyRateLimited(stats)
+	loops := t.Path.Loops()
+	t.showLoops(loops)
 
 	for r, hop := range stats {
+		t.cell(r+1, ColumnNote).Text = t.Path.Note(r)
 		if hop == nil {
 			continue
 		}
-		if hop.Sent > 0 && hop.Received > 0 {
-			t.Table.GetCell(r+1, 3).Text = strconv.Itoa(hop.Received)
+		addrColor := style.CellFgColor
+		if slices.ContainsFunc(loops, hop.addr.Equal) {
+			addrColor = style.WarningFgColor
 		}
+		t.cell(r+1, ColumnAddr).SetTextColor(addrColor)
+		t.cell(r+1, ColumnName).Text = t.Names.Name(hop.addr.String())
+		t.cell(r+1, ColumnASN).Text = t.ASNs.ASN(hop.addr.String())
 		if hop.Received > 0 {
-			t.Table.GetCell(r+1, 4).Text = strconv.Itoa(hop.Received)
+			t.cell(r+1, ColumnRcvd).Text = strconv.Itoa(hop.Received)
 		}
 		if hop.Latency > 0 {
-			t.Table.GetCell(r+1, 5).Text = strconv.FormatFloat(1000*hop.Latency.Seconds(), 'f', 1, 64) + "ms"
-			t.Table.GetCell(r+1, 6).Text = Gradient(hop.Latency.Seconds(), maxLatency.Seconds(), 12)
+			latencyColor := t.Thresholds.latencyColor(hop.Latency.Seconds() / maxLatency.Seconds())
+			t.cell(r+1, ColumnLatency).SetText(t.Format.Latency(hop.Latency)).SetTextColor(latencyColor)
+			t.cell(r+1, columnLatencyGradient).SetText(t.Format.LatencyGradient(hop.Latency, maxLatency, 12)).SetTextColor(latencyColor)
+		}
+		// a hop that doesn't answer any pings has no latency, but its loss is still shown
+		if hop.Sent > 0 {
+			t.cell(r+1, ColumnSent).Text = strconv.Itoa(hop.Sent)
 			loss := 1 - float64(hop.Received)/float64(hop.Sent)
-			t.Table.GetCell(r+1, 7).Text = strconv.FormatFloat(100*loss, 'f', 1, 64) + "%"
-			t.Table.GetCell(r+1, 8).Text = Gradient(loss, 1, 12)
+			lossText, lossColor := t.Format.Loss(loss), t.Thresholds.lossColor(loss)
+			if rateLimited[r] {
+				lossText, lossColor = lossText+" (icmp limit)", style.RateLimitedFgColor
+			}
+			t.cell(r+1, ColumnLoss).SetText(lossText).SetTextColor(lossColor)
+			t.cell(r+1, columnLossGradient).SetText(t.Format.Gradient(loss, 1, 12)).SetTextColor(lossColor)
+		}
+	}
+}
+
+// pathChanged returns true if the table no longer matches the hops of the path: hops were added or removed,
+// or a hop was discovered after its row was added.
+func (t *RefreshingTable) pathChanged() bool {
+	if len(t.Path.Hops) != len(t.addrs) {
+		return true
+	}
+	for i, hop := range t.Path.Hops {
+		if hop != nil && t.addrs[i] != hop.IP.String() {
+			return true
 		}
 	}
+	return false
 }
 
 type hopStatistics struct {
@@ -117,3 +207,201 @@ func getMaxLatency(hops []*hopStatistics) time.Duration {
 	}
 	return maxLatency
 }
+
+// lastPinged returns the index of the last hop with statistics, or -1 if the destination hasn't been reached yet.
+// This is the destination, unless it isn't pinged (-skip-target), in which case it's the last hop leading to it.
+func lastPinged(hops []*hopStatistics) int {
+	if len(hops) == 0 || hops[len(hops)-1] == nil {
+		return -1
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] != nil && hops[i].Sent > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// likelyRateLimited flags the hops that show significant loss, while all hops behind them (including the destination) show little or none.
+// Such loss is typically caused by the router rate-limiting the ICMP messages it generates, rather than by dropping traffic.
+// If the destination isn't pinged, the last hop that is pinged takes its place.
+func likelyRateLimited(hops []*hopStatistics) []bool {
+	const (
+		hopLoss        = 0.2
+		downstreamLoss = 0.05
+	)
+	flagged := make([]bool, len(hops))
+	if lastPinged(hops) < 0 {
+		return flagged
+	}
+	worstDownstream := -1.0
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == nil || hops[i].Sent == 0 {
+			continue
+		}
+		l := loss(hops[i].Statistics)
+		flagged[i] = worstDownstream >= 0 && worstDownstream < downstreamLoss && l >= hopLoss
+		worstDownstream = max(worstDownstream, l)
+	}
+	return flagged
+}
+
+// handleInput handles the table's key bindings:
+//   - 'n' attaches a note to the selected hop
+//   - 't' traces a new target (if OnTargetChange is set)
+//   - space pauses or resumes refreshing the table. The path is still pinged while paused.
+//   - 's' saves a report of the path to a file in the working directory
+//
+// 'n' and 't' open a prompt in the table's title: Enter submits the typed text, Esc cancels.
+func (t *RefreshingTable) handleInput(event *tcell.EventKey) *tcell.EventKey {
+	if t.prompt != nil {
+		t.handlePromptInput(event)
+		return nil
+	}
+	if event.Key() != tcell.KeyRune {
+		return event
+	}
+	switch event.Rune() {
+	case ' ':
+		t.paused = !t.paused
+		t.Table.SetTitle(t.defaultTitle())
+		t.Refresh()
+	case 's':
+		go t.saveReport(t.target, t.Format, t.Names)
+	case 'n':
+		if row, _ := t.Table.GetSelection(); row > 0 && row <= t.Path.Len() {
+			hop := row - 1
+			t.startPrompt("note for hop "+strconv.Itoa(row), t.Path.Note(hop), func(note string) {
+				t.Path.SetNote(hop, note)
+				t.cell(row, ColumnNote).Text = note
+			})
+		}
+	case 't':
+		if t.OnTargetChange == nil {
+			return event
+		}
+		t.startPrompt("new target", "", func(host string) {
+			if host != "" {
+				t.OnTargetChange(host)
+			}
+		})
+	default:
+		return event
+	}
+	return nil
+}
+
+// showLoops warns in the table's title if the path contains a suspected routing loop.
+func (t *RefreshingTable) showLoops(loops []net.IP) {
+	t.warning = loopWarning(loops)
+	t.updateTitle()
+}
+
+// loopWarning returns the warning for the addresses that suggest a routing loop, or an empty string if there are none.
+func loopWarning(loops []net.IP) string {
+	if len(loops) == 0 {
+		return ""
+	}
+	addresses := make([]string, len(loops))
+	for i, addr := range loops {
+		addresses[i] = addr.String()
+	}
+	return "routing loop suspected: " + strings.Join(addresses, ", ")
+}
+
+// ShowDiscoveryError explains in the table's title why the path to the target could not be discovered,
+// until a new target is set.
+func (t *RefreshingTable) ShowDiscoveryError(err error) {
+	t.problem = "path discovery failed: " + err.Error()
+	if t.Path.Len() == 0 {
+		t.problem = "no responses: is ICMP blocked?"
+	}
+	t.updateTitle()
+}
+
+// saveReport saves a report of the path to a timestamped file and shows the outcome in the table's title for a while.
+// It is called outside the UI goroutine, so looking up the hops' names can't block the UI.
+func (t *RefreshingTable) saveReport(target discover.Target, format Format, names *NameResolver) {
+	const showFor = 5 * time.Second
+	text := "report saved to "
+	filename, err := saveReport(reportDir, time.Now(), target, t.Path, format, names)
+	if err == nil {
+		text += filename
+	} else {
+		text = "failed to save report: " + err.Error()
+	}
+	t.status.Store(&status{text: text, expires: time.Now().Add(showFor)})
+}
+
+// updateTitle shows the default title, unless a prompt is open.
+func (t *RefreshingTable) updateTitle() {
+	if t.prompt == nil {
+		t.Table.SetTitle(t.defaultTitle())
+	}
+}
+
+func (t *RefreshingTable) defaultTitle() string {
+	title := t.title
+	if t.paused {
+		title += "- paused "
+	}
+	if t.problem != "" {
+		title += "- " + t.problem + " "
+	}
+	if t.warning != "" {
+		title += "- " + t.warning + " "
+	}
+	if s := t.status.Load(); s != nil && time.Now().Before(s.expires) {
+		title += "- " + s.text + " "
+	}
+	return title
+}
+
+// status is a message shown in the table's title until it expires.
+type status struct {
+	expires time.Time
+	text    string
+}
+
+func (t *RefreshingTable) startPrompt(label string, text string, submit func(string)) {
+	t.prompt = &prompt{label: label, text: []rune(text), submit: submit}
+	t.Table.SetTitle(t.prompt.title())
+}
+
+func (t *RefreshingTable) handlePromptInput(event *tcell.EventKey) {
+	if t.prompt.handleInput(event) {
+		t.prompt = nil
+		t.Table.SetTitle(t.defaultTitle())
+	} else {
+		t.Table.SetTitle(t.prompt.title())
+	}
+}
+
+// prompt holds the text being typed in response to a prompt shown in a title.
+type prompt struct {
+	submit func(string)
+	label  string
+	text   []rune
+}
+
+// handleInput edits the prompt's text. Enter submits the text, Esc cancels. It returns true if the prompt is done.
+func (p *prompt) handleInput(event *tcell.EventKey) bool {
+	switch event.Key() {
+	case tcell.KeyEnter:
+		p.submit(string(p.text))
+		return true
+	case tcell.KeyEscape:
+		return true
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+	case tcell.KeyRune:
+		p.text = append(p.text, event.Rune())
+	}
+	return false
+}
+
+func (p *prompt) title() string {
+	return " " + p.label + ": " + string(p.text) + "_ "
+}
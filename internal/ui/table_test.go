@@ -1,13 +1,18 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"github.com/clambin/pinger/pkg/ping"
 	"github.com/clambin/pinger/pkg/ping/icmp"
 	"github.com/clambin/vizroute/internal/discover"
+	"github.com/gdamore/tcell/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -29,15 +34,20 @@ func TestRefreshingTable_Refresh(t *testing.T) {
 	for range 3 {
 		path.AddHop()
 	}
+	hops := make(map[uint8]*ping.Target)
 	for idx, packet := range packets {
-		h := ping.Target{IP: net.ParseIP(packet.ip)}
+		h, ok := hops[packet.hop]
+		if !ok {
+			h = &ping.Target{IP: net.ParseIP(packet.ip)}
+			hops[packet.hop] = h
+			path.SetHop(int(packet.hop-1), h)
+		}
 		h.Sent(icmp.SequenceNumber(idx + 1))
 		time.Sleep(packet.latency / 2)
 		h.Received(packet.up, icmp.SequenceNumber(idx+1))
-		path.SetHop(int(packet.hop-1), &h)
 	}
 
-	table := NewRefreshingTable("", &path)
+	table := NewRefreshingTable(discover.Target{}, &path)
 	table.Refresh()
 
 	rows := 1 + path.Len()
@@ -51,10 +61,10 @@ func TestRefreshingTable_Refresh(t *testing.T) {
 	}
 	const ignoreCell = "<ignore>"
 	want := [][]string{
-		{"hop", "addr", "name", "sent", "rcvd", "latency", "", "loss", ""},
-		{"1", "192.168.0.1", "", "1", "1", ignoreCell, ignoreCell, "0.0%", "|----------|"},
-		{"2", "", "", "", "", "", "", "", ""},
-		{"3", "192.168.0.2", "", "1", "1", ignoreCell, ignoreCell, "0.0%", "|----------|"},
+		{"hop", "addr", "name", "sent", "rcvd", "latency", "", "loss", "", "note"},
+		{"1", "192.168.0.1", "", "2", "1", ignoreCell, ignoreCell, "50.0%", ignoreCell, ""},
+		{"2", "", "", "", "", "", "", "", "", ""},
+		{"3", "192.168.0.2", "", "2", "1", ignoreCell, ignoreCell, "50.0%", ignoreCell, ""},
 	}
 	require.Equal(t, len(want), len(content))
 	for r, row := range content {
@@ -67,6 +77,262 @@ func TestRefreshingTable_Refresh(t *testing.T) {
 	}
 }
 
+func TestRefreshingTable_Note(t *testing.T) {
+	var path discover.Path
+	path.AddHop()
+	path.AddHop()
+	path.SetHop(1, &ping.Target{IP: net.ParseIP("192.168.0.1")})
+	table := NewRefreshingTable(discover.Target{}, &path)
+	table.Select(2, 0)
+
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'n', tcell.ModNone)}
+	for _, r := range "ISP handoff!" {
+		keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	keys = append(keys, tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone))
+	for _, key := range keys {
+		assert.Nil(t, table.handleInput(key))
+	}
+	assert.Equal(t, " note for hop 2: ISP handoff_ ", table.GetTitle())
+	assert.Nil(t, table.handleInput(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)))
+	assert.Equal(t, " traceroute:  ", table.GetTitle())
+
+	assert.Equal(t, "ISP handoff", path.Note(1))
+	assert.Equal(t, "ISP handoff", table.GetCell(2, 9).Text)
+
+	// resetting the statistics doesn't remove the note
+	path.Hops[1].ResetStatistics()
+	table.Refresh()
+	assert.Equal(t, "ISP handoff", table.GetCell(2, 9).Text)
+
+	// other keys are passed through when not editing
+	key := tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone)
+	assert.Equal(t, key, table.handleInput(key))
+}
+
+func TestRefreshingTable_TargetChange(t *testing.T) {
+	var path discover.Path
+	path.AddHop()
+	path.SetHop(0, &ping.Target{IP: net.ParseIP("192.168.0.1")})
+	table := NewRefreshingTable(discover.Target{Name: "old.example.com", IP: net.ParseIP("192.0.2.1")}, &path)
+
+	// without a handler, 't' is passed through
+	key := tcell.NewEventKey(tcell.KeyRune, 't', tcell.ModNone)
+	assert.Equal(t, key, table.handleInput(key))
+
+	var newHost string
+	table.OnTargetChange = func(host string) { newHost = host }
+	assert.Nil(t, table.handleInput(key))
+	for _, r := range "new.example.com" {
+		assert.Nil(t, table.handleInput(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)))
+	}
+	assert.Equal(t, " new target: new.example.com_ ", table.GetTitle())
+	assert.Nil(t, table.handleInput(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)))
+	assert.Equal(t, "new.example.com", newHost)
+
+	// the tracer restarts with an empty path: the table is rebuilt for the new target
+	path.Reset()
+	table.SetTarget(discover.Target{Name: "new.example.com", IP: net.ParseIP("192.0.2.2")})
+	assert.Equal(t, " traceroute: new.example.com (192.0.2.2) ", table.GetTitle())
+	assert.Equal(t, 1, table.GetRowCount())
+}
+
+func TestRefreshingTable_Loops(t *testing.T) {
+	var path discover.Path
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.2"} {
+		path.AddHop()
+		path.SetHop(i, &ping.Target{IP: net.ParseIP(ip)})
+	}
+	table := NewRefreshingTable(discover.Target{Name: "example.com"}, &path)
+	table.Refresh()
+
+	assert.Equal(t, " traceroute: example.com - routing loop suspected: 10.0.0.2 ", table.GetTitle())
+	for r, want := range []tcell.Color{style.CellFgColor, style.WarningFgColor, style.CellFgColor, style.WarningFgColor} {
+		fg, _, _ := table.GetCell(r+1, 1).Style.Decompose()
+		assert.Equal(t, want, fg, r)
+	}
+}
+
+func TestRefreshingTable_LateHop(t *testing.T) {
+	var path discover.Path
+	path.AddHop()
+	path.AddHop()
+	path.SetHop(1, &ping.Target{IP: net.ParseIP("192.168.0.2")})
+	table := NewRefreshingTable(discover.Target{}, &path)
+	assert.Empty(t, table.GetCell(1, 1).Text)
+
+	// the first hop is discovered after its row was added
+	path.SetHop(0, &ping.Target{IP: net.ParseIP("192.168.0.1")})
+	table.Refresh()
+	assert.Equal(t, "192.168.0.1", table.GetCell(1, 1).Text)
+	assert.Equal(t, "192.168.0.2", table.GetCell(2, 1).Text)
+}
+
+func TestRefreshingTable_Pause(t *testing.T) {
+	var path discover.Path
+	path.AddHop()
+	path.SetHop(0, &ping.Target{IP: net.ParseIP("192.168.0.1")})
+	table := NewRefreshingTable(discover.Target{Name: "example.com"}, &path)
+	table.Names = nil
+
+	space := tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone)
+	assert.Nil(t, table.handleInput(space))
+	assert.Equal(t, " traceroute: example.com - paused ", table.GetTitle())
+
+	// while paused, new hops are not shown
+	path.AddHop()
+	path.SetHop(1, &ping.Target{IP: net.ParseIP("192.168.0.2")})
+	table.Refresh()
+	assert.Equal(t, 2, table.GetRowCount())
+
+	// resuming refreshes the table immediately
+	assert.Nil(t, table.handleInput(space))
+	assert.Equal(t, " traceroute: example.com ", table.GetTitle())
+	assert.Equal(t, 3, table.GetRowCount())
+	assert.Equal(t, "192.168.0.2", table.GetCell(2, 1).Text)
+}
+
+func TestRefreshingTable_SetColumns(t *testing.T) {
+	var path discover.Path
+	h := ping.Target{IP: net.ParseIP("192.168.0.1")}
+	h.Sent(icmp.SequenceNumber(1))
+	path.AddHop()
+	path.SetHop(0, &h)
+	path.SetNote(0, "home router")
+	table := NewRefreshingTable(discover.Target{}, &path)
+	table.Names = nil
+
+	table.SetColumns([]Column{ColumnNote, ColumnLoss, ColumnAddr})
+	table.Refresh()
+	assert.Equal(t, [][]string{
+		{"note", "loss", "", "addr"},
+		{"home router", "100.0%", "|**********|", "192.168.0.1"},
+	}, readTable(table))
+
+	// notes entered with 'n' go to the note column, wherever it is
+	for _, key := range []*tcell.EventKey{
+		tcell.NewEventKey(tcell.KeyRune, 'n', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone),
+	} {
+		table.handleInput(key)
+	}
+	assert.Equal(t, "home route", table.GetCell(1, 0).Text)
+	assert.Equal(t, "192.168.0.1", table.GetCell(1, 3).Text)
+}
+
+func TestRefreshingTable_SaveReport(t *testing.T) {
+	reportDir = t.TempDir()
+	t.Cleanup(func() { reportDir = "." })
+
+	var path discover.Path
+	path.AddHop()
+	path.SetHop(0, &ping.Target{IP: net.ParseIP("192.168.0.1")})
+	table := NewRefreshingTable(discover.Target{Name: "example.com"}, &path)
+	table.Names = nil
+
+	// a note entered with 'n' is included in the report
+	table.Select(1, 0)
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'n', tcell.ModNone)}
+	for _, r := range "ISP handoff" {
+		keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	keys = append(keys, tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	for _, key := range keys {
+		assert.Nil(t, table.handleInput(key))
+	}
+
+	assert.Nil(t, table.handleInput(tcell.NewEventKey(tcell.KeyRune, 's', tcell.ModNone)))
+	var files []string
+	assert.Eventually(t, func() bool {
+		files, _ = filepath.Glob(filepath.Join(reportDir, "vizroute-*.txt"))
+		return len(files) == 1 && table.status.Load() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	report, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(report), "traceroute: example.com")
+	assert.Contains(t, string(report), "192.168.0.1")
+	assert.Contains(t, string(report), "ISP handoff")
+
+	// the outcome is shown in the title on the next refresh
+	table.Refresh()
+	assert.Equal(t, " traceroute: example.com - report saved to "+files[0]+" ", table.GetTitle())
+}
+
+func TestRefreshingTable_RateLimited(t *testing.T) {
+	var path discover.Path
+	for i, ip := range []string{"192.168.0.1", "192.168.0.2", "192.168.0.3"} {
+		h := ping.Target{IP: net.ParseIP(ip)}
+		for seq := range 10 {
+			h.Sent(icmp.SequenceNumber(seq))
+			// hop 2 doesn't answer any pings, while the hops around it answer all of them
+			h.Received(i != 1, icmp.SequenceNumber(seq))
+		}
+		path.AddHop()
+		path.SetHop(i, &h)
+	}
+	table := NewRefreshingTable(discover.Target{}, &path)
+	table.Names = nil
+	table.Refresh()
+
+	assert.Equal(t, "0.0%", table.cell(1, ColumnLoss).Text)
+	assert.Equal(t, "100.0% (icmp limit)", table.cell(2, ColumnLoss).Text)
+	fg, _, _ := table.cell(2, ColumnLoss).Style.Decompose()
+	assert.Equal(t, style.RateLimitedFgColor, fg)
+	assert.Empty(t, table.cell(2, ColumnLatency).Text)
+	assert.Equal(t, "0.0%", table.cell(3, ColumnLoss).Text)
+}
+
+func TestRefreshingTable_Thresholds(t *testing.T) {
+	var path discover.Path
+	// hop 1 loses 0%, hop 2 loses 10%, hop 3 loses 40%, hop 4 loses everything: none of the hops is rate-limited
+	for i, received := range []int{10, 9, 6, 0} {
+		h := ping.Target{IP: net.ParseIP("192.168.0." + strconv.Itoa(i+1))}
+		for seq := range 10 {
+			h.Sent(icmp.SequenceNumber(seq))
+			h.Received(seq < received, icmp.SequenceNumber(seq))
+		}
+		path.AddHop()
+		path.SetHop(i, &h)
+	}
+	table := NewRefreshingTable(discover.Target{}, &path)
+	table.Names = nil
+	table.Thresholds = Thresholds{LossFair: 0.01, LossBad: 0.15, LatencyFair: 2, LatencyBad: 2}
+	table.Refresh()
+
+	for r, want := range []tcell.Color{style.GoodFgColor, style.FairFgColor, style.BadFgColor, style.BadFgColor} {
+		fg, _, _ := table.cell(r+1, ColumnLoss).Style.Decompose()
+		assert.Equal(t, want, fg, r)
+		fg, _, _ = table.cell(r+1, columnLossGradient).Style.Decompose()
+		assert.Equal(t, want, fg, r)
+		if r < 3 {
+			fg, _, _ = table.cell(r+1, ColumnLatency).Style.Decompose()
+			assert.Equal(t, style.GoodFgColor, fg, r)
+		}
+	}
+	assert.Equal(t, "100.0%", table.cell(4, ColumnLoss).Text)
+	assert.Equal(t, "|**********|", table.cell(4, columnLossGradient).Text)
+}
+
+func TestRefreshingTable_ShowDiscoveryError(t *testing.T) {
+	var path discover.Path
+	table := NewRefreshingTable(discover.Target{Name: "example.com"}, &path)
+
+	table.ShowDiscoveryError(errors.New("no path found"))
+	assert.Equal(t, " traceroute: example.com - no responses: is ICMP blocked? ", table.GetTitle())
+
+	path.AddHop()
+	table.ShowDiscoveryError(errors.New("no path found"))
+	table.Refresh()
+	assert.Equal(t, " traceroute: example.com - path discovery failed: no path found ", table.GetTitle())
+
+	// a new target clears the error
+	table.SetTarget(discover.Target{Name: "example.org"})
+	table.Refresh()
+	assert.Equal(t, " traceroute: example.org ", table.GetTitle())
+}
+
 func readTable(table *RefreshingTable) [][]string {
 	rows := table.GetRowCount()
 	content := make([][]string, rows)
@@ -79,3 +345,50 @@ func readTable(table *RefreshingTable) [][]string {
 	}
 	return content
 }
+
+func TestLikelyRateLimited(t *testing.T) {
+	hop := func(sent, received int) *hopStatistics {
+		return &hopStatistics{Statistics: ping.Statistics{Sent: sent, Received: received, Latency: time.Millisecond}}
+	}
+	tests := []struct {
+		name string
+		hops []*hopStatistics
+		want []bool
+	}{
+		{
+			name: "lossy middle hop, clean target",
+			hops: []*hopStatistics{hop(10, 10), hop(10, 4), nil, hop(10, 10)},
+			want: []bool{false, true, false, false},
+		},
+		{
+			name: "silent middle hop, clean target",
+			hops: []*hopStatistics{hop(10, 10), hop(10, 0), hop(10, 10)},
+			want: []bool{false, true, false},
+		},
+		{
+			name: "loss carries through to the target",
+			hops: []*hopStatistics{hop(10, 10), hop(10, 4), hop(10, 5)},
+			want: []bool{false, false, false},
+		},
+		{
+			name: "target not measured",
+			hops: []*hopStatistics{hop(10, 4), nil},
+			want: []bool{false, false},
+		},
+		{
+			name: "target not pinged",
+			hops: []*hopStatistics{hop(10, 10), hop(10, 4), hop(10, 10), hop(0, 0)},
+			want: []bool{false, true, false, false},
+		},
+		{
+			name: "lossy target",
+			hops: []*hopStatistics{hop(10, 10), hop(10, 4)},
+			want: []bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, likelyRateLimited(tt.hops))
+		})
+	}
+}
@@ -2,36 +2,157 @@ package ui
 
 import (
 	"context"
+	"fmt"
 	"github.com/clambin/vizroute/internal/discover"
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"io"
 	"time"
 )
 
 type UI struct {
-	Root      *tview.Grid
-	LogViewer *tview.TextView
+	Root      *tview.Pages
+	LogViewer *LogViewer
 	*RefreshingTable
+	Peer    *RefreshingTable
+	Summary *tview.TextView
+	grid    *tview.Grid
+	details *details
 }
 
 type Application interface {
 	QueueUpdateDraw(func()) *tview.Application
 }
 
-func New(target string, path *discover.Path, viewLogs bool) *UI {
+func New(target discover.Target, path *discover.Path, viewLogs bool) *UI {
 	ui := UI{
 		RefreshingTable: NewRefreshingTable(target, path),
-		Root:            tview.NewGrid(),
+		grid:            tview.NewGrid(),
 	}
-	ui.Root.AddItem(ui.RefreshingTable, 0, 0, 1, 1, 0, 0, true)
+	ui.grid.AddItem(ui.RefreshingTable, 0, 0, 1, 1, 0, 0, true)
 	if viewLogs {
-		ui.LogViewer = tview.NewTextView()
-		ui.LogViewer.SetBorder(true).SetTitle("logs").SetTitleAlign(tview.AlignLeft)
-		ui.LogViewer.SetScrollable(true).ScrollToEnd()
-		ui.Root.AddItem(ui.LogViewer, 1, 0, 1, 1, 0, 0, false)
+		ui.addLogViewer(1, 1)
 	}
+	ui.init()
 	return &ui
 }
 
+// NewCompare creates a UI that shows the IPv4 and the IPv6 path to the same target side by side,
+// with a summary line stating which of the two is healthier.
+func NewCompare(v4Target, v6Target discover.Target, v4, v6 *discover.Path, viewLogs bool) *UI {
+	ui := UI{
+		RefreshingTable: NewRefreshingTable(v4Target, v4),
+		Peer:            NewRefreshingTable(v6Target, v6),
+		Summary:         tview.NewTextView(),
+		grid:            tview.NewGrid(),
+	}
+	ui.grid.SetRows(0, 1)
+	ui.grid.AddItem(ui.RefreshingTable, 0, 0, 1, 1, 0, 0, true)
+	ui.grid.AddItem(ui.Peer, 0, 1, 1, 1, 0, 0, false)
+	ui.grid.AddItem(ui.Summary, 1, 0, 1, 2, 0, 0, false)
+	if viewLogs {
+		ui.grid.SetRows(0, 1, 0)
+		ui.addLogViewer(2, 2)
+	}
+	ui.init()
+	return &ui
+}
+
+// init puts the layout in Root, opens a hop's details when Enter is pressed on its row and handles the log viewer's filter.
+func (u *UI) init() {
+	u.Root = tview.NewPages().AddPage(mainPage, u.grid, true, true)
+	u.Root.SetInputCapture(u.handleInput)
+	for _, table := range []*RefreshingTable{u.RefreshingTable, u.Peer} {
+		if table != nil {
+			table.SetSelectedFunc(func(row, _ int) { u.showDetails(table, row) })
+		}
+	}
+}
+
+// SetFormat sets how latency and loss are rendered in all tables.
+func (u *UI) SetFormat(format Format) {
+	u.RefreshingTable.Format = format
+	if u.Peer != nil {
+		u.Peer.Format = format
+	}
+}
+
+// SetThresholds sets the thresholds that determine the color of the latency and loss cells in all tables.
+func (u *UI) SetThresholds(thresholds Thresholds) {
+	u.RefreshingTable.Thresholds = thresholds
+	if u.Peer != nil {
+		u.Peer.Thresholds = thresholds
+	}
+}
+
+// SetNameResolver sets the resolver used by all tables to show the hops' host names.
+func (u *UI) SetNameResolver(names *NameResolver) {
+	u.RefreshingTable.Names = names
+	if u.Peer != nil {
+		u.Peer.Names = names
+	}
+}
+
+// SetASNResolver sets the resolver used by all tables to show the AS of the hops' addresses.
+func (u *UI) SetASNResolver(asns *ASNResolver) {
+	u.RefreshingTable.ASNs = asns
+	if u.Peer != nil {
+		u.Peer.ASNs = asns
+	}
+}
+
+// SetColumns sets the columns shown in all tables.
+func (u *UI) SetColumns(columns []Column) {
+	u.RefreshingTable.SetColumns(columns)
+	if u.Peer != nil {
+		u.Peer.SetColumns(columns)
+	}
+}
+
+// WriteReport writes a report of each table's path to w, for the target it currently shows.
+// It must not be called while the UI is running.
+func (u *UI) WriteReport(w io.Writer) error {
+	for _, table := range []*RefreshingTable{u.RefreshingTable, u.Peer} {
+		if table == nil {
+			continue
+		}
+		if table != u.RefreshingTable {
+			_, _ = fmt.Fprintln(w)
+		}
+		if err := WriteReport(w, table.target, table.Path, table.Format, table.Names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *UI) addLogViewer(row, colSpan int) {
+	u.LogViewer = newLogViewer()
+	u.grid.AddItem(u.LogViewer, row, 0, 1, colSpan, 0, 0, false)
+}
+
+// handleInput opens the log viewer's filter prompt when '/' is pressed, and passes the keys typed in it to the prompt.
+// '/' is passed on while a table is prompting for input or a hop's details are shown.
+func (u *UI) handleInput(event *tcell.EventKey) *tcell.EventKey {
+	if u.LogViewer == nil {
+		return event
+	}
+	if u.LogViewer.prompt != nil {
+		u.LogViewer.handlePromptInput(event)
+		return nil
+	}
+	if event.Key() != tcell.KeyRune || event.Rune() != '/' || u.details != nil {
+		return event
+	}
+	for _, table := range []*RefreshingTable{u.RefreshingTable, u.Peer} {
+		if table != nil && table.prompt != nil {
+			return event
+		}
+	}
+	u.LogViewer.startFilterPrompt()
+	return nil
+}
+
 func (u *UI) Update(ctx context.Context, app Application, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -42,6 +163,13 @@ func (u *UI) Update(ctx context.Context, app Application, interval time.Duration
 		case <-ticker.C:
 			app.QueueUpdateDraw(func() {
 				u.RefreshingTable.Refresh()
+				if u.Peer != nil {
+					u.Peer.Refresh()
+					u.Summary.SetText(compareSummary(u.RefreshingTable.Path, u.Peer.Path))
+				}
+				if u.details != nil {
+					u.details.refresh()
+				}
 			})
 		}
 	}
@@ -8,7 +8,9 @@ import (
 	"github.com/rivo/tview"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"net"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -29,7 +31,12 @@ func TestUI_Update(t *testing.T) {
 	h.Received(true, 1)
 	path.AddHop()
 	path.SetHop(0, &h)
-	tui := New("1.1.1.1", &path, true)
+	tui := New(discover.Target{Name: "one.one.one.one", IP: net.ParseIP("1.1.1.1")}, &path, true)
+	tui.SetNameResolver(newNameResolver(func(string) ([]string, error) {
+		return []string{"one.one.one.one."}, nil
+	}, time.Hour))
+	// resolve the name up front, so the table shows it on its first refresh
+	tui.Names.Resolve("1.1.1.1")
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -43,9 +50,53 @@ func TestUI_Update(t *testing.T) {
 	cancel()
 	<-done
 
+	assert.Equal(t, " traceroute: one.one.one.one (1.1.1.1) ", tui.GetTitle())
 	content := readTable(tui.RefreshingTable)
 	assert.Equal(t, [][]string{
-		{"hop", "addr", "name", "sent", "rcvd", "latency", "", "loss", ""},
-		{"1", "1.1.1.1", "one.one.one.one.", "1", "1", "0.0ms", "|**********|", "0.0%", "|----------|"},
+		{"hop", "addr", "name", "sent", "rcvd", "latency", "", "loss", "", "note"},
+		{"1", "1.1.1.1", "one.one.one.one.", "1", "1", "0.0ms", "|**********|", "0.0%", "|----------|", ""},
 	}, content)
 }
+
+func TestUI_WriteReport(t *testing.T) {
+	var v4, v6 discover.Path
+	v4.AddHop()
+	v4.SetHop(0, &ping.Target{IP: net.ParseIP("192.0.2.1")})
+	v6.AddHop()
+	v6.SetHop(0, &ping.Target{IP: net.ParseIP("2001:db8::1")})
+
+	tests := []struct {
+		name string
+		ui   *UI
+		want []string
+	}{
+		{
+			name: "single",
+			ui:   New(discover.Target{Name: "example.com", IP: net.ParseIP("192.0.2.1")}, &v4, false),
+			want: []string{"traceroute: example.com (192.0.2.1)"},
+		},
+		{
+			name: "compare",
+			ui: NewCompare(
+				discover.Target{Name: "example.com", IP: net.ParseIP("192.0.2.1")},
+				discover.Target{Name: "example.com", IP: net.ParseIP("2001:db8::1")},
+				&v4, &v6, false,
+			),
+			want: []string{"traceroute: example.com (192.0.2.1)", "traceroute: example.com (2001:db8::1)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ui.SetNameResolver(nil)
+			var output strings.Builder
+			require.NoError(t, tt.ui.WriteReport(&output))
+			var titles []string
+			for _, line := range strings.Split(output.String(), "\n") {
+				if strings.HasPrefix(line, "traceroute: ") {
+					titles = append(titles, strings.TrimSpace(line))
+				}
+			}
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
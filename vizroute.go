@@ -6,26 +6,59 @@ import (
 	"fmt"
 	"github.com/clambin/pinger/pkg/ping"
 	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/clambin/vizroute/internal/asn"
 	"github.com/clambin/vizroute/internal/discover"
 	"github.com/clambin/vizroute/internal/ui"
 	"github.com/rivo/tview"
+	"golang.org/x/term"
 	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"time"
 	//_ "net/http/pprof"
 )
 
 var (
-	ipv6     = flag.Bool("6", false, "Use IPv6")
-	debug    = flag.Bool("debug", false, "Enable debug logging")
-	showLogs = flag.Bool("logs", false, "Show logging")
-	maxHops  = flag.Int("maxhops", 20, "Maximum number of hops to try")
+	ipv4          = flag.Bool("4", false, "Use IPv4")
+	ipv6          = flag.Bool("6", false, "Use IPv6")
+	compare46     = flag.Bool("compare46", false, "Compare the IPv4 and IPv6 path to the host")
+	debug         = flag.Bool("debug", false, "Enable debug logging")
+	showLogs      = flag.Bool("logs", false, "Show logging")
+	maxHops       = flag.Int("maxhops", 20, "Maximum number of hops to try")
+	probesPerTTL  = flag.Int("discovery-probes", 3, "Maximum number of probes sent for each hop during path discovery")
+	probeInterval = flag.Duration("probe-interval", 50*time.Millisecond, "Time between two probes during path discovery")
+	warmUp        = flag.Duration("warmup", 0, "Discard the statistics collected during this initial period")
+	statsWindow   = flag.Duration("stats-window", 0, "Clear the statistics at this interval, so they only reflect recent pings (0: never)")
+	report        = flag.Bool("report", false, "Ping the path for a number of cycles (see -c), print a report and exit. This is the default if stdout isn't a terminal")
+	count         = flag.Int("c", 10, "Number of ping cycles in report mode")
+	precision     = flag.Int("precision", ui.DefaultFormat.Precision, "Number of decimal places for latency and loss")
+	resolve       = flag.Bool("resolve", true, "Look up the host names of the hops")
+	dnsCacheTTL   = flag.Duration("dns-cache-ttl", time.Hour, "Time to cache the host names of the hops")
+	skipTarget    = flag.Bool("skip-target", false, "Don't continuously ping the target itself, only the hops leading to it. ICMP rate limit detection and the -compare46 summary then use the last hop before it")
+	interval      = flag.Duration("interval", time.Second, "Time between two pings to the same hop")
+	rateLimit     = flag.Int("rate-limit", 0, "Maximum number of probes sent per second, to avoid tripping routers' ICMP rate limiting (0: no limit)")
+	probeTimeout  = flag.Duration("probe-timeout", 5*time.Second, "Time after which an unanswered probe is counted as lost")
+	columns       = flag.String("columns", "hop,addr,name,sent,rcvd,latency,loss,note", "Comma-separated list of the columns to show (add asn to look up each hop's AS with Team Cymru)")
+	rotate        = flag.Duration("rotate", 0, "Trace each of the host's addresses in turn, switching to the next one at this interval (0: trace one address)")
+	lossFair      = flag.Float64("loss-fair", 100*ui.DefaultThresholds.LossFair, "Loss (in %) from which a hop's loss is shown as fair")
+	lossBad       = flag.Float64("loss-bad", 100*ui.DefaultThresholds.LossBad, "Loss (in %) from which a hop's loss is shown as bad")
+	blocks        = flag.Bool("blocks", false, "Draw latency and loss bars with unicode blocks")
+	logScale      = flag.Bool("log-scale", false, "Scale latency bars logarithmically")
+	themeName     = flag.String("theme", "dark", "Color theme: "+strings.Join(ui.Themes(), ", "))
+	mouse         = flag.Bool("mouse", true, "Select hops and scroll with the mouse (disable to select text in the terminal)")
+	summary       = flag.Bool("summary-on-exit", false, "Print a report of the path when the UI is closed")
+	refresh       = flag.Duration("refresh", time.Second, "Time between two updates of the screen")
+	discoverWait  = flag.Duration("discovery-timeout", 0, "Time to wait for the path to be discovered before reporting that no responses were received (0: 5s)")
 )
 
 var a *tview.Application
 
+// minRefresh is the shortest allowed time between two updates of the screen.
+const minRefresh = 50 * time.Millisecond
+
 func main() {
 	flag.Parse()
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
@@ -35,45 +68,270 @@ func main() {
 		_, _ = fmt.Fprintf(os.Stderr, "Usage: traceroute <host>\n")
 		os.Exit(1)
 	}
-	target := flag.Arg(0)
+	host := discover.ParseHost(flag.Arg(0))
+	if *precision < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid precision %d: must not be negative\n", *precision)
+		os.Exit(1)
+	}
+	if *interval <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid interval %s: must be positive\n", *interval)
+		os.Exit(1)
+	}
+	if *probeTimeout <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid probe timeout %s: must be positive\n", *probeTimeout)
+		os.Exit(1)
+	}
+	if *refresh < minRefresh {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid refresh interval %s: must be at least %s\n", *refresh, minRefresh)
+		os.Exit(1)
+	}
+	if *probesPerTTL <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid number of discovery probes %d: must be positive\n", *probesPerTTL)
+		os.Exit(1)
+	}
+	if *probeInterval <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid probe interval %s: must be positive\n", *probeInterval)
+		os.Exit(1)
+	}
+	if *count <= 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid count %d: must be positive\n", *count)
+		os.Exit(1)
+	}
+	shownColumns, err := ui.ParseColumns(*columns)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid columns %q: %s\n", *columns, err)
+		os.Exit(1)
+	}
+	if err = ui.SetTheme(*themeName); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid theme %q: must be one of %s\n", *themeName, strings.Join(ui.Themes(), ", "))
+		os.Exit(1)
+	}
+	if *lossFair < 0 || *lossBad < *lossFair {
+		_, _ = fmt.Fprintf(os.Stderr, "Invalid loss thresholds %g/%g: must be positive, with -loss-bad at least -loss-fair\n", *lossFair, *lossBad)
+		os.Exit(1)
+	}
+	thresholds := ui.DefaultThresholds
+	thresholds.LossFair, thresholds.LossBad = *lossFair/100, *lossBad/100
+	format := ui.Format{LatencyUnit: time.Millisecond, Precision: *precision, Blocks: *blocks, LogScale: *logScale}
+	var names *ui.NameResolver
+	if *resolve {
+		names = ui.NewNameResolver(*dnsCacheTTL)
+	}
 
-	var p discover.Path
-	tui := ui.New(target, &p, *showLogs)
+	var p, p6 discover.Path
+	var tui *ui.UI
+	var target, target6 discover.Target
+	if *compare46 {
+		target, target6, err = discover.ResolveFamilies(host)
+	} else {
+		// without -4 or -6, use the host's IPv6 address if it's reachable and its IPv4 address otherwise
+		var family = icmp.IPv4 | icmp.IPv6
+		switch {
+		case *ipv4:
+			family = icmp.IPv4
+		case *ipv6:
+			family = icmp.IPv6
+		}
+		target, err = discover.Resolve(host, family)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error resolving host %q: %s\n", host, err)
+		os.Exit(1)
+	}
+
+	if reportMode(*report) {
+		os.Exit(runReport(ctx, target, format, names, newLogger(os.Stderr)))
+	}
+
+	if *compare46 {
+		tui = ui.NewCompare(target, target6, &p, &p6, *showLogs)
+	} else {
+		tui = ui.New(target, &p, *showLogs)
+	}
+	tui.SetFormat(format)
+	tui.SetThresholds(thresholds)
+	tui.SetNameResolver(names)
+	tui.SetColumns(shownColumns)
+	if slices.Contains(shownColumns, ui.ColumnASN) {
+		tui.SetASNResolver(ui.NewASNResolver(asn.Cymru{}, *dnsCacheTTL))
+	}
 
 	var output io.Writer = os.Stderr
 	if *showLogs {
 		output = tui.LogViewer
 	}
+	l := newLogger(output)
+
+	a = tview.NewApplication().EnableMouse(*mouse).SetRoot(tui.Root, true)
+	if *compare46 {
+		startCompare(ctx, tui, target, target6, l)
+	} else {
+		tracer := startTracer(ctx, target, &p, tui.RefreshingTable, l)
+		rotateCtx, stopRotating := context.WithCancel(ctx)
+		defer stopRotating()
+		rotateDone := make(chan struct{})
+		if *rotate > 0 {
+			go func() {
+				defer close(rotateDone)
+				rotateTargets(ctx, rotateCtx, tracer, tui, host, target, *rotate, l)
+			}()
+		} else {
+			close(rotateDone)
+		}
+		tui.OnTargetChange = func(host string) {
+			host = discover.ParseHost(host)
+			go func() {
+				newTarget, err := discover.Resolve(host, target.Family)
+				if err != nil {
+					l.Error("failed to resolve new target", "host", host, "err", err)
+					return
+				}
+				// a new target ends rotation. Wait for it to stop, so it can't replace the new target with its next address.
+				stopRotating()
+				<-rotateDone
+				tracer.Start(ctx, newTarget)
+				a.QueueUpdateDraw(func() { tui.SetTarget(newTarget) })
+			}()
+		}
+	}
+
+	go tui.Update(ctx, a, *refresh)
+	if err := a.Run(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error starting UI: %s\n", err)
+		os.Exit(1)
+	}
+	if *summary {
+		// stop pinging, so the report shows the statistics at the time the UI was closed
+		cancel()
+		if err := tui.WriteReport(os.Stdout); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error writing report: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// isTerminal returns true if stdout is a terminal. Tests replace it.
+var isTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// reportMode returns true if the path should be printed as a report (see runReport) rather than shown in the UI: with
+// -report, or when stdout isn't a terminal, e.g. when the output is piped to a file.
+func reportMode(report bool) bool {
+	return report || !isTerminal()
+}
+
+func newLogger(output io.Writer) *slog.Logger {
 	var handlerOptions slog.HandlerOptions
 	if *debug {
 		handlerOptions.Level = slog.LevelDebug
 	}
-	l := slog.New(slog.NewTextHandler(output, &handlerOptions))
-
-	var tp = icmp.IPv4
-	if *ipv6 {
-		tp = icmp.IPv6
-	}
+	return slog.New(slog.NewTextHandler(output, &handlerOptions))
+}
 
+// newSocket creates the ICMP socket for a tracer. Tests replace it, so they don't need to send real pings.
+var newSocket = func(ctx context.Context, tp icmp.Transport, l *slog.Logger) ping.Socket {
 	s, err := icmp.New(tp, l.With("socket", tp))
 	if err != nil {
 		l.Error("failed to create icmp listener", "err", err)
 		os.Exit(1)
 	}
 	go s.Serve(ctx)
+	return s
+}
 
-	addr, err := s.Resolve(target)
-	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Error resolving host %q: %s\n", flag.Arg(0), err)
-		os.Exit(1)
+// startTracer starts tracing target on path p. Discovery errors are shown in table.
+func startTracer(ctx context.Context, target discover.Target, p *discover.Path, table *ui.RefreshingTable, l *slog.Logger) *discover.Tracer {
+	tracer := newTracer(ctx, target.Family, p, l)
+	tracer.OnDiscoveryFailed = showDiscoveryError(table)
+	tracer.Start(ctx, target)
+	return tracer
+}
+
+// startCompare starts a tracer for each address family of -compare46: one for the IPv4 target, on the path of the UI's
+// main table, and one for the IPv6 target, on the path of its peer.
+func startCompare(ctx context.Context, tui *ui.UI, target, target6 discover.Target, l *slog.Logger) (*discover.Tracer, *discover.Tracer) {
+	return startTracer(ctx, target, tui.RefreshingTable.Path, tui.RefreshingTable, l),
+		startTracer(ctx, target6, tui.Peer.Path, tui.Peer, l)
+}
+
+// showDiscoveryError returns a handler for a tracer's OnDiscoveryFailed, which shows the error in the table.
+func showDiscoveryError(table *ui.RefreshingTable) func(discover.Target, error) {
+	return func(_ discover.Target, err error) {
+		a.QueueUpdateDraw(func() { table.ShowDiscoveryError(err) })
+	}
+}
+
+func newTracer(ctx context.Context, tp icmp.Transport, p *discover.Path, l *slog.Logger) *discover.Tracer {
+	return &discover.Tracer{
+		Path:             p,
+		Socket:           newSocket(ctx, tp, l),
+		Logger:           l,
+		Options:          pingOptions(),
+		MaxTTL:           uint8(*maxHops),
+		ProbesPerTTL:     *probesPerTTL,
+		ProbeInterval:    *probeInterval,
+		RateLimit:        *rateLimit,
+		DiscoveryTimeout: *discoverWait,
 	}
+}
+
+func pingOptions() discover.PingOptions {
+	return discover.PingOptions{
+		Interval:        *interval,
+		Timeout:         *probeTimeout,
+		WarmUp:          *warmUp,
+		StatsWindow:     *statsWindow,
+		SkipDestination: *skipTarget,
+	}
+}
 
-	go func() {
-		if err = discover.Discover(ctx, &p, addr, s, uint8(*maxHops), l); err == nil {
-			ping.Ping(ctx, p.Hops, s, time.Second, 5*time.Second, l)
+// rotateTargets traces each of the host's addresses of the target's family in turn, switching to the next one at every interval,
+// until rotateCtx is done. It starts with the address following the one being traced. The traces run until ctx is done,
+// so the last one keeps running when rotation stops.
+func rotateTargets(ctx, rotateCtx context.Context, tracer *discover.Tracer, tui *ui.UI, host string, target discover.Target, interval time.Duration, l *slog.Logger) {
+	targets, err := discover.ResolveAll(host, target.Family)
+	if err != nil {
+		l.Error("failed to resolve target", "host", host, "err", err)
+		return
+	}
+	if len(targets) < 2 {
+		l.Info("not rotating: host has a single address", "host", host)
+		return
+	}
+	current := slices.IndexFunc(targets, func(t discover.Target) bool { return t.IP.Equal(target.IP) })
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rotateCtx.Done():
+			return
+		case <-ticker.C:
+			current = (current + 1) % len(targets)
+			next := targets[current]
+			l.Info("rotating to next address", "target", next)
+			tracer.Start(ctx, next)
+			a.QueueUpdateDraw(func() { tui.SetTarget(next) })
 		}
-	}()
-	a = tview.NewApplication().SetRoot(tui.Root, true)
-	go tui.Update(ctx, a, time.Second)
-	_ = a.Run()
+	}
+}
+
+// runReport discovers the path to the target, pings it for the requested number of cycles and prints a report.
+// It returns the program's exit code: non-zero if the target was not reached.
+func runReport(ctx context.Context, target discover.Target, format ui.Format, names *ui.NameResolver, l *slog.Logger) int {
+	var p discover.Path
+	tracer := newTracer(ctx, target.Family, &p, l)
+	exitCode := 0
+	// the first ping is sent after one interval: stop half an interval after the last one is sent.
+	// Trace then waits for the replies that are still outstanding.
+	interval := tracer.Options.Interval
+	if err := tracer.Trace(ctx, target, time.Duration(*count)*interval+interval/2); err != nil {
+		l.Error("path discovery failed", "err", err)
+		exitCode = 1
+	}
+	if err := ui.WriteReport(os.Stdout, target, &p, format, names); err != nil {
+		l.Error("failed to write report", "err", err)
+		exitCode = 1
+	}
+	return exitCode
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"github.com/clambin/pinger/pkg/ping"
+	"github.com/clambin/pinger/pkg/ping/icmp"
+	"github.com/clambin/vizroute/internal/discover"
+	"github.com/clambin/vizroute/internal/ui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	neticmp "golang.org/x/net/icmp"
+	netipv4 "golang.org/x/net/ipv4"
+	netipv6 "golang.org/x/net/ipv6"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartCompare(t *testing.T) {
+	sockets := make(map[icmp.Transport]*echoSocket)
+	var lock sync.Mutex
+	newSocketOrig := newSocket
+	t.Cleanup(func() { newSocket = newSocketOrig })
+	newSocket = func(_ context.Context, tp icmp.Transport, _ *slog.Logger) ping.Socket {
+		lock.Lock()
+		defer lock.Unlock()
+		s := &echoSocket{replies: make(chan icmp.Response, 100)}
+		sockets[tp] = s
+		return s
+	}
+
+	target := discover.Target{Name: "example.org", IP: net.ParseIP("192.0.2.1"), Family: icmp.IPv4}
+	target6 := discover.Target{Name: "example.org", IP: net.ParseIP("2001:db8::1"), Family: icmp.IPv6}
+	var p, p6 discover.Path
+	tui := ui.NewCompare(target, target6, &p, &p6, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracer, tracer6 := startCompare(ctx, tui, target, target6, slog.Default())
+
+	// each family's tracer discovers its own path, shown in its own table
+	assert.Same(t, &p, tracer.Path)
+	assert.Same(t, &p6, tracer6.Path)
+	assert.Eventually(t, func() bool { return p.Len() == 1 && p6.Len() == 1 }, time.Second, 10*time.Millisecond)
+
+	// each tracer has a socket of its own family, which only pings that family's target
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, sockets, 2)
+	assert.Equal(t, []string{target.IP.String()}, sockets[icmp.IPv4].Pinged())
+	assert.Equal(t, []string{target6.IP.String()}, sockets[icmp.IPv6].Pinged())
+}
+
+func TestReportMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   bool
+		terminal bool
+		want     bool
+	}{
+		{name: "ui", report: false, terminal: true, want: false},
+		{name: "report", report: true, terminal: true, want: true},
+		{name: "no terminal", report: false, terminal: false, want: true},
+	}
+	isTerminalOrig := isTerminal
+	t.Cleanup(func() { isTerminal = isTerminalOrig })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isTerminal = func() bool { return tt.terminal }
+			assert.Equal(t, tt.want, reportMode(tt.report))
+		})
+	}
+}
+
+// echoSocket answers every ping with an echo reply from the pinged address.
+type echoSocket struct {
+	replies chan icmp.Response
+	pinged  map[string]struct{}
+	lock    sync.Mutex
+}
+
+func (s *echoSocket) Ping(ip net.IP, seq icmp.SequenceNumber, _ uint8, payload []byte) error {
+	s.lock.Lock()
+	if s.pinged == nil {
+		s.pinged = make(map[string]struct{})
+	}
+	s.pinged[ip.String()] = struct{}{}
+	s.lock.Unlock()
+	var msgType neticmp.Type = netipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		msgType = netipv6.ICMPTypeEchoReply
+	}
+	select {
+	case s.replies <- icmp.Response{From: ip, MsgType: msgType, Body: &neticmp.Echo{Seq: int(seq), Data: payload}, Received: time.Now()}:
+	default:
+	}
+	return nil
+}
+
+func (s *echoSocket) Read(ctx context.Context) (icmp.Response, error) {
+	select {
+	case <-ctx.Done():
+		return icmp.Response{}, ctx.Err()
+	case resp := <-s.replies:
+		return resp, nil
+	}
+}
+
+func (s *echoSocket) Resolve(host string) (net.IP, error) {
+	return net.ParseIP(host), nil
+}
+
+func (s *echoSocket) Serve(context.Context) {}
+
+// Pinged returns the addresses pinged through the socket.
+func (s *echoSocket) Pinged() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pinged := make([]string, 0, len(s.pinged))
+	for ip := range s.pinged {
+		pinged = append(pinged, ip)
+	}
+	return pinged
+}